@@ -0,0 +1,542 @@
+package cms
+
+import (
+    "log"
+    "os"
+    "time"
+    "strconv"
+    "strings"
+    "net/http"
+    "path/filepath"
+    "encoding/json"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+)
+
+const API_V2_PREFIX = "/api/v2"
+
+// Regular expression for MongoDB object ids in URL routes
+const OBJECT_ID_PATTERN = "[0-9a-fA-F]{24}"
+
+/* Helpers */
+
+// Encodes the value as JSON and writes it with the given status code
+func writeJSON(c http.ResponseWriter, status int, value interface{}) {
+    b, err := json.Marshal(value)
+    if err != nil {
+        log.Println("error:", err)
+        http.Error(c, "Server error", http.StatusInternalServerError)
+        return
+    }
+
+    c.Header().Set("Content-Type", "application/json")
+    c.Header().Set("Content-Length", strconv.Itoa(len(b)))
+    c.WriteHeader(status)
+    c.Write(b)
+}
+
+// Encodes the value as JSON and writes it, unless the client already has this
+// version. An empty tag is computed from the encoded body.
+func writeJSONCached(c http.ResponseWriter, req *http.Request, value interface{}, tag string, modified time.Time) {
+    b, err := json.Marshal(value)
+    if err != nil {
+        log.Println("error:", err)
+        http.Error(c, "Server error", http.StatusInternalServerError)
+        return
+    }
+
+    if tag == "" {
+        tag = contentTag(b)
+    }
+    c.Header().Set("Content-Type", "application/json")
+    if checkNotModified(c, req, tag, modified) {
+        return
+    }
+
+    c.Header().Set("Content-Length", strconv.Itoa(len(b)))
+    c.Write(b)
+}
+
+// Writes the error of an update, 412 when the document changed meanwhile and
+// 400 when its new slug is taken or invalid, or a category is wrong
+func writeUpdateError(c http.ResponseWriter, err error) {
+    if err == ErrModified {
+        writeJSONError(c, http.StatusPreconditionFailed, err.Error())
+    } else if err == ErrSlugTaken || err == ErrInvalidSlug || err == ErrUnknownCategory || err == ErrCategoryCycle {
+        writeJSONError(c, http.StatusBadRequest, err.Error())
+    } else {
+        writeJSONError(c, http.StatusInternalServerError, err.Error())
+    }
+}
+
+// Writes an error message as JSON
+func writeJSONError(c http.ResponseWriter, status int, message string) {
+    writeJSON(c, status, map[string]string{"result":"error", "message":message})
+}
+
+// Answers with 405 and the list of allowed methods
+func methodNotAllowed(c http.ResponseWriter, allowed ...string) {
+    c.Header().Set("Allow", strings.Join(allowed, ", "))
+    writeJSONError(c, http.StatusMethodNotAllowed, "Invalid method.")
+}
+
+// Returns true for requests that only read, GET and HEAD
+func isReadRequest(req *http.Request) bool {
+    return req.Method == "GET" || req.Method == "HEAD"
+}
+
+// Returns false and answers with 401 when the session isn't superuser
+func checkSuperuser(c http.ResponseWriter, req *http.Request) bool {
+    if !IsSuperuser(c, req) {
+        writeJSONError(c, http.StatusUnauthorized, "Unauthorized")
+        return false
+    }
+    return true
+}
+
+/* Blog posts */
+
+// Blog posts collection: GET lists, POST creates
+func PostCollectionHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    switch req.Method {
+    case "GET", "HEAD":
+        opts, err := parseListOptions(c, req, blogPostSortFields, "-pubdate", DEFAULT_PAGE_LIMIT)
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+        posts, total, err := FindBlogPosts(db, opts)
+        if err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        excerptPosts(req, posts)
+        setPaginationHeaders(c, req, opts, total)
+        writeJSONCached(c, req, map[string]interface{}{"posts":posts, "total":total, "page":opts.Page, "limit":opts.Limit}, "", time.Time{})
+
+    case "POST":
+        if !checkSuperuser(c, req) {
+            return
+        }
+        payload, err := parseContentPayload(req)
+        if err == nil {
+            err = payload.validate(false)
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        post := BlogPost{Published:true, Author:DEFAULT_AUTHOR, Tags:make([]string,0)}
+        payload.applyToBlogPost(&post)
+        if post.Slug == "" {
+            post.Slug = Slugify(post.Title)
+        }
+        if err = InsertNewBlogPost(db, &post); err != nil {
+            writeUpdateError(c, err)
+            return
+        }
+        c.Header().Set("Location", API_V2_PREFIX + "/posts/" + post.Id.Hex() + "/")
+        writeJSON(c, http.StatusCreated, post)
+
+    default:
+        methodNotAllowed(c, "GET", "HEAD", "POST")
+    }
+}
+
+// Single blog post: GET, PUT replaces, PATCH updates the sent fields, DELETE removes
+func PostResourceHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+    postId := mux.Vars(req)["postId"]
+
+    if !isReadRequest(req) && req.Method != "PUT" && req.Method != "PATCH" && req.Method != "DELETE" {
+        methodNotAllowed(c, "GET", "HEAD", "PUT", "PATCH", "DELETE")
+        return
+    } else if !isReadRequest(req) && !checkSuperuser(c, req) {
+        return
+    }
+
+    // Drafts are only seen by superusers
+    post, err := GetBlogPost(db, postId)
+    if err != nil || (!post.Published && !IsSuperuser(c, req)) {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+
+    // Changes must be made over the current version
+    tag := documentTag(post.Id, post.LastModified())
+    if !isReadRequest(req) && req.Header.Get("If-Match") != "" && !tagMatches(req.Header.Get("If-Match"), tag) {
+        writeJSONError(c, http.StatusPreconditionFailed, ErrModified.Error())
+        return
+    }
+
+    switch req.Method {
+    case "GET", "HEAD":
+        writeJSONCached(c, req, post, tag, post.LastModified())
+
+    case "PUT", "PATCH":
+        payload, err := parseContentPayload(req)
+        if err == nil && req.Method == "PUT" {
+            err = payload.validate(true)
+            payload.fillDefaults()
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        payload.applyToBlogPost(&post)
+        if err = UpdateBlogPost(db, &post); err != nil {
+            writeUpdateError(c, err)
+            return
+        }
+        c.Header().Set("ETag", documentTag(post.Id, post.LastModified()))
+        writeJSON(c, http.StatusOK, post)
+
+    case "DELETE":
+        if err = DeleteBlogPost(db, postId); err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        c.WriteHeader(http.StatusNoContent)
+    }
+}
+
+/* Pages */
+
+// Pages collection: GET lists, POST creates
+func PageCollectionHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    switch req.Method {
+    case "GET", "HEAD":
+        opts, err := parseListOptions(c, req, pageSortFields, "title", DEFAULT_PAGE_LIMIT)
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+        pages, total, err := FindPages(db, opts)
+        if err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        setPaginationHeaders(c, req, opts, total)
+        writeJSONCached(c, req, map[string]interface{}{"pages":pages, "total":total, "page":opts.Page, "limit":opts.Limit}, "", time.Time{})
+
+    case "POST":
+        if !checkSuperuser(c, req) {
+            return
+        }
+        payload, err := parseContentPayload(req)
+        if err == nil {
+            err = payload.validate(true)
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        page := Page{Published:true, Author:DEFAULT_AUTHOR, Tags:make([]string,0)}
+        payload.applyToPage(&page)
+        if err = InsertNewPage(db, &page); err != nil {
+            writeUpdateError(c, err)
+            return
+        }
+        c.Header().Set("Location", API_V2_PREFIX + "/pages/" + page.Id.Hex() + "/")
+        writeJSON(c, http.StatusCreated, page)
+
+    default:
+        methodNotAllowed(c, "GET", "HEAD", "POST")
+    }
+}
+
+// Single page: GET, PUT replaces, PATCH updates the sent fields, DELETE removes
+func PageResourceHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+    pageId := mux.Vars(req)["pageId"]
+
+    if !isReadRequest(req) && req.Method != "PUT" && req.Method != "PATCH" && req.Method != "DELETE" {
+        methodNotAllowed(c, "GET", "HEAD", "PUT", "PATCH", "DELETE")
+        return
+    } else if !isReadRequest(req) && !checkSuperuser(c, req) {
+        return
+    }
+
+    // Drafts are only seen by superusers
+    page, err := GetPage(db, pageId)
+    if err != nil || (!page.Published && !IsSuperuser(c, req)) {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+
+    // Changes must be made over the current version
+    tag := documentTag(page.Id, page.LastModified())
+    if !isReadRequest(req) && req.Header.Get("If-Match") != "" && !tagMatches(req.Header.Get("If-Match"), tag) {
+        writeJSONError(c, http.StatusPreconditionFailed, ErrModified.Error())
+        return
+    }
+
+    switch req.Method {
+    case "GET", "HEAD":
+        writeJSONCached(c, req, page, tag, page.LastModified())
+
+    case "PUT", "PATCH":
+        payload, err := parseContentPayload(req)
+        if err == nil && req.Method == "PUT" {
+            err = payload.validate(true)
+            payload.fillDefaults()
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        payload.applyToPage(&page)
+        if err = UpdatePage(db, &page); err != nil {
+            writeUpdateError(c, err)
+            return
+        }
+        c.Header().Set("ETag", documentTag(page.Id, page.LastModified()))
+        writeJSON(c, http.StatusOK, page)
+
+    case "DELETE":
+        if err = DeletePage(db, pageId); err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        c.WriteHeader(http.StatusNoContent)
+    }
+}
+
+/* Photos */
+
+// Photos collection: GET lists, POST uploads the multipart "media" files
+func PhotoCollectionHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    switch req.Method {
+    case "GET", "HEAD":
+        opts, err := parseListOptions(c, req, photoSortFields, "-pubdate", DEFAULT_PAGE_LIMIT)
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+        photos, total, err := FindPhotos(db, opts)
+        if err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        setPaginationHeaders(c, req, opts, total)
+        writeJSONCached(c, req, map[string]interface{}{"photos":photos, "total":total, "page":opts.Page, "limit":opts.Limit}, "", time.Time{})
+
+    case "POST":
+        if !checkSuperuser(c, req) {
+            return
+        }
+        if err := req.ParseMultipartForm(32 << 20); err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+        files := req.MultipartForm.File["media"]
+        if len(files) == 0 {
+            writeJSONError(c, http.StatusBadRequest, "media is required")
+            return
+        }
+
+        photos := make([]Photo,0)
+        for _, handler := range files {
+            photo, err := savePhotoUpload(handler)
+            if err != nil {
+                writeJSONError(c, http.StatusInternalServerError, err.Error())
+                return
+            }
+            photos = append(photos, photo)
+        }
+        writeJSON(c, http.StatusCreated, map[string]interface{}{"photos":photos})
+
+    default:
+        methodNotAllowed(c, "GET", "HEAD", "POST")
+    }
+}
+
+// Single photo: GET, PUT/PATCH update tags and publishing, DELETE removes it and its file
+func PhotoResourceHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+    photoId := mux.Vars(req)["photoId"]
+
+    if !isReadRequest(req) && req.Method != "PUT" && req.Method != "PATCH" && req.Method != "DELETE" {
+        methodNotAllowed(c, "GET", "HEAD", "PUT", "PATCH", "DELETE")
+        return
+    } else if !isReadRequest(req) && !checkSuperuser(c, req) {
+        return
+    }
+
+    // Drafts are only seen by superusers
+    photo, err := GetPhoto(db, photoId)
+    if err != nil || (!photo.Published && !IsSuperuser(c, req)) {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+
+    // Changes must be made over the current version
+    tag := documentTag(photo.Id, photo.LastModified())
+    if !isReadRequest(req) && req.Header.Get("If-Match") != "" && !tagMatches(req.Header.Get("If-Match"), tag) {
+        writeJSONError(c, http.StatusPreconditionFailed, ErrModified.Error())
+        return
+    }
+
+    switch req.Method {
+    case "GET", "HEAD":
+        writeJSONCached(c, req, photo, tag, photo.LastModified())
+
+    case "PUT", "PATCH":
+        payload, err := parseContentPayload(req)
+        if err == nil && req.Method == "PUT" {
+            payload.fillDefaults()
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        payload.applyToPhoto(&photo)
+        if err = UpdatePhoto(db, &photo); err != nil {
+            writeUpdateError(c, err)
+            return
+        }
+        c.Header().Set("ETag", documentTag(photo.Id, photo.LastModified()))
+        writeJSON(c, http.StatusOK, photo)
+
+    case "DELETE":
+        if err = DeletePhoto(db, photoId); err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        if err = os.Remove(filepath.Join(systemConf.PhotosRoot, photo.Filename)); err != nil {
+            log.Println(err)
+        }
+        c.WriteHeader(http.StatusNoContent)
+    }
+}
+
+/* Menu items */
+
+// Menu items collection: GET lists, POST creates
+func MenuItemCollectionHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    switch req.Method {
+    case "GET", "HEAD":
+        items, err := ListMenuItems(db)
+        if err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        writeJSONCached(c, req, map[string]interface{}{"items":items}, "", time.Time{})
+
+    case "POST":
+        if !checkSuperuser(c, req) {
+            return
+        }
+        payload, err := parseMenuItemPayload(req)
+        if err == nil {
+            err = payload.validate()
+        }
+        if err == nil && (payload.Id == nil || *payload.Id == "") {
+            payload.Id = new(string)
+            *payload.Id = "menu-" + Slugify(*payload.Label)
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        item := MenuItem{Id:*payload.Id}
+        payload.applyToMenuItem(&item)
+        if err = InsertNewMenuItem(db, &item); mgo.IsDup(err) {
+            writeJSONError(c, http.StatusConflict, "Menu item " + item.Id + " exists")
+            return
+        } else if err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        c.Header().Set("Location", API_V2_PREFIX + "/menu-items/" + item.Id + "/")
+        writeJSON(c, http.StatusCreated, item)
+
+    default:
+        methodNotAllowed(c, "GET", "HEAD", "POST")
+    }
+}
+
+// Single menu item: GET, PUT replaces, PATCH updates the sent fields, DELETE removes
+func MenuItemResourceHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+    itemId := mux.Vars(req)["itemId"]
+
+    if !isReadRequest(req) && req.Method != "PUT" && req.Method != "PATCH" && req.Method != "DELETE" {
+        methodNotAllowed(c, "GET", "HEAD", "PUT", "PATCH", "DELETE")
+        return
+    } else if !isReadRequest(req) && !checkSuperuser(c, req) {
+        return
+    }
+
+    item, err := GetMenuItem(db, itemId)
+    if err != nil {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+
+    switch req.Method {
+    case "GET", "HEAD":
+        writeJSONCached(c, req, item, "", time.Time{})
+
+    case "PUT", "PATCH":
+        payload, err := parseMenuItemPayload(req)
+        if err == nil && req.Method == "PUT" {
+            err = payload.validate()
+            payload.fillDefaults()
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        payload.applyToMenuItem(&item)
+        if err = UpdateMenuItem(db, &item); err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        writeJSON(c, http.StatusOK, item)
+
+    case "DELETE":
+        if err = DeleteMenuItem(db, itemId); err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        c.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// Registers the versioned REST routes
+func setApiV2Urls(r *mux.Router) {
+    r.HandleFunc(API_V2_PREFIX + "/posts/", PostCollectionHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/posts/bulk/", PostBulkHandler).Methods("POST", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/posts/{postId:" + OBJECT_ID_PATTERN + "}/", PostResourceHandler).Methods("GET", "HEAD", "PUT", "PATCH", "DELETE", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/pages/", PageCollectionHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/pages/bulk/", PageBulkHandler).Methods("POST", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/pages/{pageId:" + OBJECT_ID_PATTERN + "}/", PageResourceHandler).Methods("GET", "HEAD", "PUT", "PATCH", "DELETE", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/photos/", PhotoCollectionHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/photos/{photoId:" + OBJECT_ID_PATTERN + "}/", PhotoResourceHandler).Methods("GET", "HEAD", "PUT", "PATCH", "DELETE", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/menu-items/", MenuItemCollectionHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/menu-items/{itemId:[\\w\\-]+}/", MenuItemResourceHandler).Methods("GET", "HEAD", "PUT", "PATCH", "DELETE", "OPTIONS")
+}
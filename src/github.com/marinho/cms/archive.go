@@ -0,0 +1,161 @@
+package cms
+
+import (
+    "fmt"
+    "log"
+    "time"
+    "strconv"
+    "net/http"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+// Published posts in a month. Dates are grouped in UTC, like permalinks.
+type ArchiveMonth struct {
+    Year int
+    Month int
+    Name string // Like "March"
+    Posts int
+}
+
+// Published posts in a year, with its months, newest first
+type ArchiveYear struct {
+    Year int
+    Posts int
+    Months []ArchiveMonth
+}
+
+// Returns the path of the month's archive page
+func (month ArchiveMonth) Url() string {
+    return fmt.Sprintf("/archive/%04d/%02d/", month.Year, month.Month)
+}
+
+// Returns the path of the year's archive page
+func (year ArchiveYear) Url() string {
+    return fmt.Sprintf("/archive/%04d/", year.Year)
+}
+
+// Returns the years and months with published posts, newest first
+func GetBlogArchive(db *mgo.Database) ([]ArchiveYear, error) {
+    var groups []struct {
+        Id struct {
+            Year int `bson:"year"`
+            Month int `bson:"month"`
+        } `bson:"_id"`
+        Count int `bson:"count"`
+    }
+    pipeline := []bson.M{
+        {"$match":bson.M{"published":true}},
+        {"$group":bson.M{"_id":bson.M{"year":bson.M{"$year":"$pubdate"}, "month":bson.M{"$month":"$pubdate"}}, "count":bson.M{"$sum":1}}},
+        {"$sort":bson.D{{Name:"_id.year", Value:-1}, {Name:"_id.month", Value:-1}}},
+    }
+    years := make([]ArchiveYear,0)
+    if err := db.C(BLOG_POST_COLL_NAME).Pipe(pipeline).All(&groups); err != nil {
+        return years, err
+    }
+
+    for _, group := range groups {
+        if len(years) == 0 || years[len(years)-1].Year != group.Id.Year {
+            years = append(years, ArchiveYear{Year:group.Id.Year, Months:make([]ArchiveMonth,0)})
+        }
+        year := &years[len(years)-1]
+        year.Posts += group.Count
+        year.Months = append(year.Months, ArchiveMonth{Year:group.Id.Year, Month:group.Id.Month,
+            Name:time.Month(group.Id.Month).String(), Posts:group.Count})
+    }
+    return years, nil
+}
+
+// Returns the published posts of a year, or of a month if it isn't zero,
+// newest first
+func GetArchivePosts(db *mgo.Database, year int, month int) ([]BlogPost, error) {
+    since := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+    until := since.AddDate(1, 0, 0)
+    if month != 0 {
+        since = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+        until = since.AddDate(0, 1, 0)
+    }
+
+    published := true
+    posts, _, err := FindBlogPosts(db, ListOptions{Since:since, Until:until.Add(-time.Millisecond),
+        Sort:[]string{"-pubdate"}, Published:&published})
+    return posts, err
+}
+
+/* HANDLERS */
+
+// Years and months with published posts, and how many
+func BlogArchiveHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        methodNotAllowed(c, "GET", "HEAD")
+        return
+    }
+
+    years, err := GetBlogArchive(dbDefaultConn.DB(systemConf.DBName))
+    if err != nil {
+        writeJSONError(c, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSONCached(c, req, map[string]interface{}{"years":years}, "", time.Time{})
+}
+
+// Archive page of a year or a month, with its posts and the archive index
+func ArchiveViewHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    if req.Method != "GET" && req.Method != "HEAD" {
+        http.Error(c, "Invalid method.", http.StatusMethodNotAllowed)
+        return
+    }
+
+    args := mux.Vars(req)
+    year, _ := strconv.Atoi(args["year"])
+    month := 0
+    if args["month"] != "" {
+        month, _ = strconv.Atoi(args["month"])
+        if month < 1 || month > 12 {
+            http.Error(c, "Invalid month", http.StatusNotFound)
+            return
+        }
+    }
+
+    title := strconv.Itoa(year)
+    if month != 0 {
+        title = time.Month(month).String() + " " + title
+    }
+
+    years, err := GetBlogArchive(db)
+    if err == nil {
+        var posts []BlogPost
+        posts, err = GetArchivePosts(db, year, month)
+        if err == nil {
+            var data string
+            data, err = renderServerTemplate("archive.html", map[string]interface{}{
+                "Title": title,
+                "Posts": posts,
+                "Years": years,
+            })
+            if err == nil {
+                c.Header().Set("Content-Type", "text/html; charset=utf-8")
+                writeCached(c, req, data, time.Time{})
+                return
+            }
+        }
+    }
+
+    log.Println(err)
+    http.Error(c, "Server error", http.StatusInternalServerError)
+}
+
+// Registers the archive routes
+func setArchiveUrls(r *mux.Router) {
+    r.HandleFunc("/api/blog/archive/", BlogArchiveHandler).Methods("GET", "HEAD", "OPTIONS")
+    yearPath := "/archive/{year:[0-9]{4}}"
+    monthPath := yearPath + "/{month:[0-9]{2}}"
+    for _, path := range []string{yearPath, yearPath + "/", monthPath, monthPath + "/"} {
+        r.HandleFunc(path, ArchiveViewHandler)
+    }
+}
@@ -0,0 +1,148 @@
+package cms
+
+import (
+    "fmt"
+    "log"
+    "time"
+    "bytes"
+    "net/http"
+    "path/filepath"
+    "html/template"
+    "encoding/json"
+    "github.com/gorilla/mux"
+    "github.com/russross/blackfriday"
+)
+
+// Values of Configuration.PostPermalinks
+const PERMALINKS_SLUG = "slug" // /blog/{slug}/, the default
+const PERMALINKS_DATE = "date" // /blog/{year}/{month}/{day}/{slug}/
+
+// Title and address of a post, to link to it
+type PostLink struct {
+    Id string
+    Title string
+    Permalink string
+}
+
+// Returns the path of the post's page, in the configured permalink format
+func (post BlogPost) Url() string {
+    if systemConf.PostPermalinks == PERMALINKS_DATE {
+        date := post.PubDate.UTC()
+        return fmt.Sprintf("/blog/%04d/%02d/%02d/%v/", date.Year(), date.Month(), date.Day(), post.Slug)
+    }
+    return "/blog/" + post.Slug + "/"
+}
+
+// Encodes the post with its permalink
+func (post BlogPost) MarshalJSON() ([]byte, error) {
+    type plainBlogPost BlogPost
+    post.Permalink = post.Url()
+    return json.Marshal(plainBlogPost(post))
+}
+
+// Returns a link to the post, nil for no post
+func postLink(post *BlogPost) *PostLink {
+    if post == nil {
+        return nil
+    }
+    return &PostLink{Id:post.Id.Hex(), Title:post.Title, Permalink:post.Url()}
+}
+
+// Converts Markdown to HTML, with code blocks highlighted
+func renderMarkdown(content string) template.HTML {
+    return template.HTML(blackfriday.Markdown([]byte(content), newMarkdownRenderer(), markdownExtensions))
+}
+
+// Renders a template from the "server" folder with Go's html/template, for
+// pages rendered before the Angular app takes over. A "head" template defined
+// in it goes to the head of the page, and may include the "seo" one.
+func renderServerTemplate(templateName string, data interface{}) (string, error) {
+    tmpl, err := template.ParseFiles(filepath.Join(systemConf.TemplatesRoot, "server", templateName),
+        filepath.Join(systemConf.TemplatesRoot, "server", "seo.html"))
+    if err != nil {
+        return "", err
+    }
+
+    var content, head bytes.Buffer
+    if err = tmpl.Execute(&content, data); err != nil {
+        return "", err
+    }
+    if headTmpl := tmpl.Lookup("head"); headTmpl != nil {
+        if err = headTmpl.Execute(&head, data); err != nil {
+            return "", err
+        }
+    }
+    return renderWithHead(content.String(), head.String())
+}
+
+// Page of a blog post, with links to the previous and next ones. Old slugs and
+// other permalink formats are redirected to the post's permalink.
+func BlogPostViewHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    if req.Method != "GET" && req.Method != "HEAD" {
+        http.Error(c, "Invalid method.", http.StatusMethodNotAllowed)
+        return
+    }
+
+    slug := mux.Vars(req)["postSlug"]
+    post, err := GetBlogPostBySlug(db, slug)
+    if err != nil {
+        post, err = GetBlogPostByOldSlug(db, slug)
+    }
+    if err != nil || (!post.Published && !IsSuperuser(c, req)) {
+        http.Error(c, fmt.Sprintf("Post \"%v\" not found", slug), http.StatusNotFound)
+        return
+    }
+
+    if req.URL.Path != post.Url() {
+        redirectPermanently(c, req, post.Url())
+        return
+    }
+
+    previous, next, err := GetAdjacentBlogPosts(db, post)
+    var categories []Category
+    var related []PostLink
+    var meta seoMeta
+    if err == nil {
+        categories, err = GetPostCategories(db, post)
+    }
+    if err == nil {
+        related, err = GetRelatedPosts(db, post)
+    }
+    if err == nil {
+        meta, err = postSeoMeta(db, req, post, categories)
+    }
+    if err == nil {
+        var data string
+        data, err = renderServerTemplate("post.html", map[string]interface{}{
+            "Post": post,
+            "Seo": meta,
+            "Content": renderPublicContent(db, post.Content),
+            "Tags": tagLinks(post.Tags, post.TagSlugs),
+            "Categories": categories,
+            "Previous": postLink(previous),
+            "Next": postLink(next),
+            "Related": related,
+        })
+        if err == nil {
+            c.Header().Set("Content-Type", "text/html; charset=utf-8")
+            // Not the post's modification time, as the links change with other posts
+            writeCached(c, req, data, time.Time{})
+            return
+        }
+    }
+
+    log.Println(err)
+    http.Error(c, "Server error", http.StatusInternalServerError)
+}
+
+// Registers the public routes of blog posts, for both permalink formats
+func setBlogUrls(r *mux.Router) {
+    slugPath := "/blog/{postSlug:[\\w\\-]+}"
+    datePath := "/blog/{year:[0-9]{4}}/{month:[0-9]{2}}/{day:[0-9]{2}}/{postSlug:[\\w\\-]+}"
+    for _, path := range []string{slugPath, slugPath + "/", datePath, datePath + "/"} {
+        r.HandleFunc(path, BlogPostViewHandler)
+    }
+}
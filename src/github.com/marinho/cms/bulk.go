@@ -0,0 +1,98 @@
+package cms
+
+import (
+    "log"
+    "net/http"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+const MAX_BULK_ITEMS = 500
+
+// Outcome of a bulk operation on one item
+type BulkResult struct {
+    Id string `json:"id"`
+    Result string `json:"result"` // ok or error
+    Message string `json:"message,omitempty"`
+}
+
+// Reads and validates a bulk operation, then runs it on each item. Items fail
+// on their own, so the response lists the result of each one.
+func runBulkOperation(c http.ResponseWriter, req *http.Request, update func(id string, payload BulkPayload) error, remove func(id string) error) {
+    log.Println(req.Method, req.URL)
+    if req.Method != "POST" {
+        methodNotAllowed(c, "POST")
+        return
+    } else if !checkSuperuser(c, req) {
+        return
+    }
+
+    payload, err := parseBulkPayload(req)
+    if err == nil {
+        err = payload.validate()
+    }
+    if err != nil {
+        writeJSONError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    results := make([]BulkResult,0)
+    succeeded := 0
+    for _, id := range payload.Ids {
+        if !bson.IsObjectIdHex(id) {
+            err = mgo.ErrNotFound
+        } else if payload.Action == "delete" {
+            err = remove(id)
+        } else {
+            err = update(id, payload)
+        }
+
+        switch {
+        case err == nil:
+            results = append(results, BulkResult{Id:id, Result:"ok"})
+            succeeded++
+        case err == mgo.ErrNotFound:
+            results = append(results, BulkResult{Id:id, Result:"error", Message:"Not found"})
+        default:
+            results = append(results, BulkResult{Id:id, Result:"error", Message:err.Error()})
+        }
+    }
+
+    writeJSON(c, http.StatusOK, map[string]interface{}{"results":results, "succeeded":succeeded, "failed":len(results) - succeeded})
+}
+
+// Runs a bulk operation on blog posts
+func PostBulkHandler(c http.ResponseWriter, req *http.Request) {
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    update := func(id string, payload BulkPayload) error {
+        post, err := GetBlogPost(db, id)
+        if err != nil {
+            return err
+        }
+        payload.applyToContent(&post.Published, &post.Tags, &post.Author)
+        return UpdateBlogPost(db, &post)
+    }
+    remove := func(id string) error {
+        return DeleteBlogPost(db, id)
+    }
+    runBulkOperation(c, req, update, remove)
+}
+
+// Runs a bulk operation on pages
+func PageBulkHandler(c http.ResponseWriter, req *http.Request) {
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    update := func(id string, payload BulkPayload) error {
+        page, err := GetPage(db, id)
+        if err != nil {
+            return err
+        }
+        payload.applyToContent(&page.Published, &page.Tags, &page.Author)
+        return UpdatePage(db, &page)
+    }
+    remove := func(id string) error {
+        return DeletePage(db, id)
+    }
+    runBulkOperation(c, req, update, remove)
+}
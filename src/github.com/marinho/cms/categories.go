@@ -0,0 +1,491 @@
+package cms
+
+import (
+    "fmt"
+    "log"
+    "time"
+    "errors"
+    "net/http"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+const CATEGORY_COLL_NAME = "categories"
+type Category struct {
+    Id bson.ObjectId `bson:"_id,omitempty"`
+    Slug string
+    Name string
+    Description string
+    Parent bson.ObjectId `bson:",omitempty"` // Empty for top level categories
+    Position int // Order among its siblings
+    Modified time.Time
+}
+
+// A category with its subcategories, and the number of published posts in it
+type CategoryNode struct {
+    Category
+    Posts int // Not counting the ones in subcategories
+    Children []CategoryNode
+}
+
+var ErrUnknownCategory = errors.New("Category doesn't exist")
+var ErrCategoryCycle = errors.New("A category can't be nested under itself or its subcategories")
+
+func (category Category) LastModified() time.Time {
+    return category.Modified
+}
+
+// Returns the path of the category's archive page
+func (category Category) Url() string {
+    return "/category/" + category.Slug + "/"
+}
+
+// Returns all categories, by position and name
+func ListCategories(db *mgo.Database) ([]Category, error) {
+    categories := make([]Category,0)
+    err := db.C(CATEGORY_COLL_NAME).Find(nil).Sort("position", "name").All(&categories)
+    return categories, err
+}
+
+func GetCategory(db *mgo.Database, categoryId string) (Category,error) {
+    category := Category{}
+    if !bson.IsObjectIdHex(categoryId) {
+        return category, mgo.ErrNotFound
+    }
+    err := db.C(CATEGORY_COLL_NAME).FindId(bson.ObjectIdHex(categoryId)).One(&category)
+    return category, err
+}
+
+func GetCategoryBySlug(db *mgo.Database, slug string) (Category,error) {
+    category := Category{}
+    err := db.C(CATEGORY_COLL_NAME).Find(bson.M{"slug":slug}).One(&category)
+    return category, err
+}
+
+// Returns the ids of the category and all its subcategories
+func categoryDescendants(categories []Category, id bson.ObjectId) []bson.ObjectId {
+    ids := []bson.ObjectId{id}
+    for i := 0; i < len(ids); i++ {
+        for _, category := range categories {
+            if category.Parent == ids[i] {
+                ids = append(ids, category.Id)
+            }
+        }
+    }
+    return ids
+}
+
+// Returns the ancestors of the category, from the top level one, and the
+// category itself
+func categoryPath(categories []Category, category Category) []Category {
+    path := []Category{category}
+    // Bounded, in case the stored parents make a cycle
+    for parent := category.Parent; parent != "" && len(path) <= len(categories); {
+        found := false
+        for _, other := range categories {
+            if other.Id == parent {
+                path = append([]Category{other}, path...)
+                parent, found = other.Parent, true
+                break
+            }
+        }
+        if !found {
+            break
+        }
+    }
+    return path
+}
+
+// Returns the ids of the category with the slug and its subcategories, or
+// mgo.ErrNotFound
+func categoryTreeIds(db *mgo.Database, slug string) ([]bson.ObjectId, error) {
+    categories, err := ListCategories(db)
+    if err != nil {
+        return nil, err
+    }
+    for _, category := range categories {
+        if category.Slug == slug {
+            return categoryDescendants(categories, category.Id), nil
+        }
+    }
+    return nil, mgo.ErrNotFound
+}
+
+// Returns the categories a post is in, by position and name
+func GetPostCategories(db *mgo.Database, post BlogPost) ([]Category, error) {
+    categories := make([]Category,0)
+    if len(post.Categories) == 0 {
+        return categories, nil
+    }
+    err := db.C(CATEGORY_COLL_NAME).Find(bson.M{"_id":bson.M{"$in":post.Categories}}).Sort("position", "name").All(&categories)
+    return categories, err
+}
+
+// Returns ErrUnknownCategory if any of the ids isn't a category
+func checkCategories(db *mgo.Database, ids []bson.ObjectId) error {
+    if len(ids) == 0 {
+        return nil
+    }
+    count, err := db.C(CATEGORY_COLL_NAME).Find(bson.M{"_id":bson.M{"$in":ids}}).Count()
+    if err == nil && count < len(ids) {
+        err = ErrUnknownCategory
+    }
+    return err
+}
+
+// Checks the parent of the category exists and isn't the category itself or
+// one of its subcategories
+func checkCategoryParent(db *mgo.Database, category *Category) error {
+    if category.Parent == "" {
+        return nil
+    }
+    categories, err := ListCategories(db)
+    if err != nil {
+        return err
+    }
+
+    found := false
+    for _, other := range categories {
+        found = found || other.Id == category.Parent
+    }
+    if !found {
+        return ErrUnknownCategory
+    }
+    if category.Id != "" {
+        for _, id := range categoryDescendants(categories, category.Id) {
+            if id == category.Parent {
+                return ErrCategoryCycle
+            }
+        }
+    }
+    return nil
+}
+
+func InsertNewCategory(db *mgo.Database, category *Category) error {
+    coll := db.C(CATEGORY_COLL_NAME)
+
+    category.Id = bson.NewObjectId()
+    if err := checkCategoryParent(db, category); err != nil {
+        return err
+    }
+    if category.Slug == "" {
+        category.Slug = category.Name
+    }
+    category.Slug = Slugify(category.Slug)
+    category.Modified = modificationTime()
+
+    // Insert, with a slug no other category has
+    err := insertWithUniqueSlug(coll, &category.Slug, category.Id, category)
+    if err == nil {
+        contentChanged()
+    }
+    return err
+}
+
+func UpdateCategory(db *mgo.Database, category *Category) error {
+    coll := db.C(CATEGORY_COLL_NAME)
+
+    category.Slug = Slugify(category.Slug)
+    if category.Slug == "" {
+        return ErrInvalidSlug
+    } else if err := checkSlugFree(coll, category.Slug, category.Id); err != nil {
+        return err
+    } else if err := checkCategoryParent(db, category); err != nil {
+        return err
+    }
+
+    loaded := category.Modified
+    category.Modified = modificationTime()
+    err := updateUnmodified(coll, category.Id, loaded, category)
+    if mgo.IsDup(err) {
+        err = ErrSlugTaken
+    }
+    if err != nil {
+        category.Modified = loaded
+    } else {
+        contentChanged()
+    }
+    return err
+}
+
+// Removes a category. Its subcategories move to its parent, and its posts
+// stay in their other categories.
+func DeleteCategory(db *mgo.Database, categoryId string) error {
+    category, err := GetCategory(db, categoryId)
+    if err != nil {
+        return err
+    }
+
+    change := bson.M{"$set":bson.M{"parent":category.Parent}}
+    if category.Parent == "" {
+        change = bson.M{"$unset":bson.M{"parent":1}}
+    }
+    _, err = db.C(CATEGORY_COLL_NAME).UpdateAll(bson.M{"parent":category.Id}, change)
+
+    // Its posts change, so they're loaded to be notified as updated
+    var posts []BlogPost
+    modified := modificationTime()
+    if err == nil {
+        err = db.C(BLOG_POST_COLL_NAME).Find(bson.M{"categories":category.Id}).All(&posts)
+    }
+    if err == nil {
+        _, err = db.C(BLOG_POST_COLL_NAME).UpdateAll(bson.M{"categories":category.Id},
+            bson.M{"$pull":bson.M{"categories":category.Id}, "$set":bson.M{"modified":modified}})
+    }
+    if err == nil {
+        err = db.C(CATEGORY_COLL_NAME).RemoveId(category.Id)
+    }
+    if err == nil {
+        contentChanged()
+        for _, post := range posts {
+            categories := make([]bson.ObjectId,0)
+            for _, id := range post.Categories {
+                if id != category.Id {
+                    categories = append(categories, id)
+                }
+            }
+            post.Categories, post.Modified = categories, modified
+            triggerWebhooks(db, "post.updated", post)
+        }
+    }
+    return err
+}
+
+// Returns the categories nested as a tree, with the number of published posts
+// in each one
+func CategoryTree(db *mgo.Database) ([]CategoryNode, error) {
+    categories, err := ListCategories(db)
+    if err != nil {
+        return nil, err
+    }
+
+    counts := make(map[bson.ObjectId]int)
+    var post struct {
+        Categories []bson.ObjectId
+    }
+    iter := db.C(BLOG_POST_COLL_NAME).Find(bson.M{"published":true, "categories":bson.M{"$ne":nil}}).Select(bson.M{"categories":1}).Iter()
+    for iter.Next(&post) {
+        for _, id := range post.Categories {
+            counts[id]++
+        }
+    }
+    if err = iter.Close(); err != nil {
+        return nil, err
+    }
+
+    // Categories whose parent is gone are shown at the top level
+    exists := make(map[bson.ObjectId]bool)
+    for _, category := range categories {
+        exists[category.Id] = true
+    }
+    var children func(parent bson.ObjectId) []CategoryNode
+    children = func(parent bson.ObjectId) []CategoryNode {
+        nodes := make([]CategoryNode,0)
+        for _, category := range categories {
+            if category.Parent == parent || (parent == "" && !exists[category.Parent]) {
+                nodes = append(nodes, CategoryNode{Category:category, Posts:counts[category.Id], Children:children(category.Id)})
+            }
+        }
+        return nodes
+    }
+    return children(""), nil
+}
+
+/* HANDLERS */
+
+// Category tree, for menus and the admin
+func CategoryTreeHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        methodNotAllowed(c, "GET", "HEAD")
+        return
+    }
+
+    tree, err := CategoryTree(dbDefaultConn.DB(systemConf.DBName))
+    if err != nil {
+        writeJSONError(c, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSONCached(c, req, map[string]interface{}{"categories":tree}, "", time.Time{})
+}
+
+// A category by slug, with the path from its top level category
+func CategoryInfoHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        methodNotAllowed(c, "GET", "HEAD")
+        return
+    }
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    category, err := GetCategoryBySlug(db, mux.Vars(req)["categorySlug"])
+    if err != nil {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+    categories, err := ListCategories(db)
+    if err != nil {
+        writeJSONError(c, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSONCached(c, req, map[string]interface{}{"result":"ok", "category":category, "path":categoryPath(categories, category)}, "", time.Time{})
+}
+
+// Categories collection: GET lists them flat, POST creates
+func CategoryCollectionHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    switch req.Method {
+    case "GET", "HEAD":
+        categories, err := ListCategories(db)
+        if err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        writeJSONCached(c, req, map[string]interface{}{"categories":categories}, "", time.Time{})
+
+    case "POST":
+        if !checkSuperuser(c, req) {
+            return
+        }
+        payload, err := parseCategoryPayload(req)
+        if err == nil {
+            err = payload.validate(true)
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        category := Category{}
+        payload.applyToCategory(&category)
+        if err = InsertNewCategory(db, &category); err != nil {
+            writeUpdateError(c, err)
+            return
+        }
+        c.Header().Set("Location", API_V2_PREFIX + "/categories/" + category.Id.Hex() + "/")
+        writeJSON(c, http.StatusCreated, category)
+
+    default:
+        methodNotAllowed(c, "GET", "HEAD", "POST")
+    }
+}
+
+// Single category: GET, PUT replaces, PATCH updates the sent fields, DELETE removes
+func CategoryResourceHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+    categoryId := mux.Vars(req)["categoryId"]
+
+    if !isReadRequest(req) && req.Method != "PUT" && req.Method != "PATCH" && req.Method != "DELETE" {
+        methodNotAllowed(c, "GET", "HEAD", "PUT", "PATCH", "DELETE")
+        return
+    } else if !isReadRequest(req) && !checkSuperuser(c, req) {
+        return
+    }
+
+    category, err := GetCategory(db, categoryId)
+    if err != nil {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+
+    // Changes must be made over the current version
+    tag := documentTag(category.Id, category.LastModified())
+    if !isReadRequest(req) && req.Header.Get("If-Match") != "" && !tagMatches(req.Header.Get("If-Match"), tag) {
+        writeJSONError(c, http.StatusPreconditionFailed, ErrModified.Error())
+        return
+    }
+
+    switch req.Method {
+    case "GET", "HEAD":
+        writeJSONCached(c, req, category, tag, category.LastModified())
+
+    case "PUT", "PATCH":
+        payload, err := parseCategoryPayload(req)
+        if err == nil {
+            err = payload.validate(req.Method == "PUT")
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        payload.applyToCategory(&category)
+        if err = UpdateCategory(db, &category); err != nil {
+            writeUpdateError(c, err)
+            return
+        }
+        c.Header().Set("ETag", documentTag(category.Id, category.LastModified()))
+        writeJSON(c, http.StatusOK, category)
+
+    case "DELETE":
+        if err = DeleteCategory(db, categoryId); err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        c.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// Archive page of a category, with the published posts in it and in its
+// subcategories
+func CategoryViewHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    if req.Method != "GET" && req.Method != "HEAD" {
+        http.Error(c, "Invalid method.", http.StatusMethodNotAllowed)
+        return
+    }
+
+    slug := mux.Vars(req)["categorySlug"]
+    category, err := GetCategoryBySlug(db, slug)
+    if err != nil {
+        http.Error(c, fmt.Sprintf("Category \"%v\" not found", slug), http.StatusNotFound)
+        return
+    }
+
+    categories, err := ListCategories(db)
+    if err == nil {
+        subcategories := make([]Category,0)
+        for _, other := range categories {
+            if other.Parent == category.Id {
+                subcategories = append(subcategories, other)
+            }
+        }
+
+        path := categoryPath(categories, category)
+        var posts []BlogPost
+        posts, err = GetRecentBlogPosts(db, category.Slug)
+        if err == nil {
+            var data string
+            data, err = renderServerTemplate("category.html", map[string]interface{}{
+                "Category": category,
+                "Parents": path[:len(path)-1],
+                "Subcategories": subcategories,
+                "Posts": posts,
+            })
+            if err == nil {
+                c.Header().Set("Content-Type", "text/html; charset=utf-8")
+                writeCached(c, req, data, time.Time{})
+                return
+            }
+        }
+    }
+
+    log.Println(err)
+    http.Error(c, "Server error", http.StatusInternalServerError)
+}
+
+// Registers the category routes
+func setCategoryUrls(r *mux.Router) {
+    r.HandleFunc("/api/category/", CategoryTreeHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/api/category/{categorySlug:[\\w\\-]+}/", CategoryInfoHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/categories/", CategoryCollectionHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    r.HandleFunc(API_V2_PREFIX + "/categories/{categoryId:" + OBJECT_ID_PATTERN + "}/", CategoryResourceHandler).Methods("GET", "HEAD", "PUT", "PATCH", "DELETE", "OPTIONS")
+    r.HandleFunc("/category/{categorySlug:[\\w\\-]+}", CategoryViewHandler)
+    r.HandleFunc("/category/{categorySlug:[\\w\\-]+}/", CategoryViewHandler)
+}
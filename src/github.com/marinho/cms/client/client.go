@@ -0,0 +1,203 @@
+// Package client talks to the CMS API (version 2) over HTTP, so tools that
+// publish to the site don't have to build requests by hand.
+package client
+
+import (
+    "io"
+    "fmt"
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "strconv"
+    "strings"
+    "net/url"
+    "net/http"
+    "net/http/cookiejar"
+    "encoding/json"
+)
+
+// Returned for responses with an error status code
+type Error struct {
+    StatusCode int
+    Message string
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("%d %v: %v", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Returns true if the error is a 404 response
+func IsNotFound(err error) bool {
+    apiErr, ok := err.(*Error)
+    return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Returns true if the error is a 412 response, i.e. the document was changed
+// by someone else since it was loaded
+func IsConflict(err error) bool {
+    apiErr, ok := err.(*Error)
+    return ok && apiErr.StatusCode == http.StatusPreconditionFailed
+}
+
+type Client struct {
+    BaseUrl string
+    Token string // Sent as "Authorization: Bearer", if not empty
+    HTTPClient *http.Client
+}
+
+// Returns a client for the site at baseUrl, like "http://localhost:8080".
+// Sessions started with Login are kept in a cookie jar.
+func New(baseUrl string) *Client {
+    jar, _ := cookiejar.New(nil)
+    return &Client{BaseUrl:baseUrl, HTTPClient:&http.Client{Jar:jar}}
+}
+
+// Returns a client authenticated with the server's API token
+func NewWithToken(baseUrl string, token string) *Client {
+    c := New(baseUrl)
+    c.Token = token
+    return c
+}
+
+// Starts a superuser session
+func (c *Client) Login(username string, password string) error {
+    var result struct {
+        Result string `json:"result"`
+        Message string `json:"message"`
+    }
+
+    body := map[string]string{"Username":username, "Password":password}
+    if err := c.do("POST", "/login/", body, "", &result); err != nil {
+        return err
+    }
+    if result.Result != "ok" {
+        return errors.New(result.Message)
+    }
+    return nil
+}
+
+// Builds and sends a request, decoding the JSON response into result. The body
+// is encoded as JSON unless it's an io.Reader, sent with contentType as is.
+func (c *Client) do(method string, path string, body interface{}, contentType string, result interface{}) error {
+    _, err := c.send(method, path, body, contentType, "", result)
+    return err
+}
+
+// Sends a request like do, with an If-Match header if ifMatch is not empty, and
+// returns the headers of the response
+func (c *Client) send(method string, path string, body interface{}, contentType string, ifMatch string, result interface{}) (http.Header, error) {
+    var reader io.Reader
+    if r, ok := body.(io.Reader); ok {
+        reader = r
+    } else if body != nil {
+        b, err := json.Marshal(body)
+        if err != nil {
+            return nil, err
+        }
+        reader = bytes.NewReader(b)
+        contentType = "application/json"
+    }
+
+    req, err := http.NewRequest(method, c.BaseUrl + path, reader)
+    if err != nil {
+        return nil, err
+    }
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    req.Header.Set("Accept", "application/json")
+    if ifMatch != "" {
+        req.Header.Set("If-Match", ifMatch)
+    }
+    if c.Token != "" {
+        req.Header.Set("Authorization", "Bearer " + c.Token)
+    }
+
+    resp, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    data, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+
+    // Errors come as {"result":"error", "message":...} or plain text
+    if resp.StatusCode >= 400 {
+        var apiErr struct {
+            Message string `json:"message"`
+        }
+        message := string(bytes.TrimSpace(data))
+        if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+            message = apiErr.Message
+        }
+        return resp.Header, &Error{StatusCode:resp.StatusCode, Message:message}
+    }
+
+    if result == nil || resp.StatusCode == http.StatusNoContent || len(data) == 0 {
+        return resp.Header, nil
+    }
+    return resp.Header, json.Unmarshal(data, result)
+}
+
+// Returns the query string for the options
+func (opts *ListOptions) query() string {
+    if opts == nil {
+        return ""
+    }
+
+    values := url.Values{}
+    if opts.Page > 0 {
+        values.Set("page", strconv.Itoa(opts.Page))
+    }
+    if opts.Limit > 0 {
+        values.Set("limit", strconv.Itoa(opts.Limit))
+    }
+    if opts.Sort != "" {
+        values.Set("sort", opts.Sort)
+    }
+    if opts.Tag != "" {
+        values.Set("tag", opts.Tag)
+    }
+    if opts.Category != "" {
+        values.Set("category", opts.Category)
+    }
+    if opts.Author != "" {
+        values.Set("author", opts.Author)
+    }
+    if !opts.Since.IsZero() {
+        values.Set("since", opts.Since.Format("2006-01-02T15:04:05Z07:00"))
+    }
+    if !opts.Until.IsZero() {
+        values.Set("until", opts.Until.Format("2006-01-02T15:04:05Z07:00"))
+    }
+    if opts.Published != "" {
+        values.Set("published", opts.Published)
+    }
+    if opts.FullContent {
+        values.Set("content", "full")
+    }
+
+    if len(values) == 0 {
+        return ""
+    }
+    return "?" + values.Encode()
+}
+
+// Returns the addresses of the Link header by rel, like "next"
+func parseLinks(header string) map[string]string {
+    links := make(map[string]string)
+    for _, link := range strings.Split(header, ",") {
+        parts := strings.Split(link, ";")
+        address := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+        for _, param := range parts[1:] {
+            param = strings.TrimSpace(param)
+            if strings.HasPrefix(param, "rel=") && address != "" {
+                links[strings.Trim(param[len("rel="):], "\"")] = address
+            }
+        }
+    }
+    return links
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+    "os"
+    "strings"
+    "testing"
+    "net/http"
+    "net/http/httptest"
+    "github.com/marinho/cms"
+)
+
+const testToken = "secret"
+
+// Serves the site's router with a test configuration. Tests that need the
+// database are skipped unless CMS_TEST_DB is the host of a MongoDB server,
+// whose "cms_client_test" database they empty.
+func newTestServer(t *testing.T, withDB bool) *httptest.Server {
+    conf := cms.Configuration{DBHostname:os.Getenv("CMS_TEST_DB"), DBName:"cms_client_test",
+        ApiToken:testToken, AuthSecret:"test", AdminUsername:"admin", AdminPassword:"password"}
+    if err := cms.Configure(conf); err != nil {
+        t.Fatal(err)
+    }
+
+    if withDB {
+        if conf.DBHostname == "" {
+            t.Skip("CMS_TEST_DB is not set")
+        }
+        session, err := cms.Connect()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err = session.DB(conf.DBName).DropDatabase(); err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(session.Close)
+    }
+
+    server := httptest.NewServer(cms.CorsMiddleware(cms.NewRouter()))
+    t.Cleanup(server.Close)
+    return server
+}
+
+func TestAuthorizationHeader(t *testing.T) {
+    server := newTestServer(t, false)
+
+    // Validation comes after authorization, so a 400 means the token was taken
+    _, err := New(server.URL).CreatePost(ContentInput{})
+    if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+        t.Errorf("Expected a 401 without a token, got %v", err)
+    }
+    _, err = NewWithToken(server.URL, testToken).CreatePost(ContentInput{})
+    if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusBadRequest {
+        t.Errorf("Expected a 400 with the token, got %v", err)
+    }
+    _, err = NewWithToken(server.URL, "wrong").CreatePost(ContentInput{})
+    if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+        t.Errorf("Expected a 401 with a wrong token, got %v", err)
+    }
+}
+
+func TestLoginSession(t *testing.T) {
+    server := newTestServer(t, false)
+    c := New(server.URL)
+
+    if err := c.Login("admin", "wrong"); err == nil {
+        t.Errorf("Expected an error with a wrong password")
+    }
+    if err := c.Login("admin", "password"); err != nil {
+        t.Fatal(err)
+    }
+    _, err := c.CreatePost(ContentInput{})
+    if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusBadRequest {
+        t.Errorf("Expected a 400 with the session, got %v", err)
+    }
+}
+
+func TestErrorDecoding(t *testing.T) {
+    server := newTestServer(t, false)
+
+    // JSON errors carry their message
+    _, err := NewWithToken(server.URL, testToken).CreatePost(ContentInput{Content:String("...")})
+    apiErr, ok := err.(*Error)
+    if !ok || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "Title is required" {
+        t.Errorf("Unexpected error %#v", err)
+    }
+
+    // Plain text errors are kept as they are. Ids that aren't ObjectIds
+    // match no route.
+    _, err = New(server.URL).GetPost("missing")
+    apiErr, ok = err.(*Error)
+    if !IsNotFound(err) || apiErr.Message != "404 page not found" {
+        t.Errorf("Unexpected error %#v", err)
+    }
+}
+
+func TestListPagination(t *testing.T) {
+    server := newTestServer(t, true)
+    c := NewWithToken(server.URL, testToken)
+
+    for _, title := range []string{"One", "Two", "Three"} {
+        if _, err := c.CreatePost(ContentInput{Title:String(title), Content:String("...")}); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    first, err := c.ListPosts(&ListOptions{Page:1, Limit:2})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(first.Posts) != 2 || first.Total != 3 || first.Page != 1 || first.Limit != 2 {
+        t.Errorf("Unexpected first page: %+v", first)
+    }
+    if first.Links["prev"] != "" || !strings.Contains(first.Links["next"], "page=2") || !strings.Contains(first.Links["last"], "page=2") {
+        t.Errorf("Unexpected links of the first page: %v", first.Links)
+    }
+
+    second, err := c.ListPosts(&ListOptions{Page:2, Limit:2})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(second.Posts) != 1 || second.Links["next"] != "" || !strings.Contains(second.Links["prev"], "page=1") {
+        t.Errorf("Unexpected second page: %+v", second)
+    }
+    for _, post := range first.Posts {
+        if post.Id == second.Posts[0].Id {
+            t.Errorf("Post %v is in both pages", post.Id)
+        }
+    }
+}
+
+func TestIfMatchConflict(t *testing.T) {
+    server := newTestServer(t, true)
+    c := NewWithToken(server.URL, testToken)
+
+    created, err := c.CreatePost(ContentInput{Title:String("One"), Content:String("...")})
+    if err != nil {
+        t.Fatal(err)
+    }
+    post, err := c.GetPost(created.Id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if post.ETag == "" {
+        t.Fatal("Expected an ETag")
+    }
+
+    updated, err := c.PatchPost(post.Id, ContentInput{Title:String("Uno"), IfMatch:post.ETag})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if updated.ETag == "" || updated.ETag == post.ETag {
+        t.Errorf("Expected a new ETag, got %v", updated.ETag)
+    }
+
+    // The ETag loaded first is out of date now
+    _, err = c.PatchPost(post.Id, ContentInput{Title:String("Eins"), IfMatch:post.ETag})
+    if !IsConflict(err) {
+        t.Errorf("Expected a conflict, got %v", err)
+    }
+    if current, err := c.GetPost(post.Id); err != nil || current.Title != "Uno" {
+        t.Errorf("Expected the first change to stay, got %+v, %v", current, err)
+    }
+}
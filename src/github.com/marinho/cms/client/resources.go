@@ -0,0 +1,267 @@
+package client
+
+import (
+    "io"
+    "bytes"
+    "strings"
+    "net/url"
+    "net/textproto"
+    "mime/multipart"
+)
+
+/* Blog posts */
+
+func (c *Client) ListPosts(opts *ListOptions) (*PostList, error) {
+    list := new(PostList)
+    header, err := c.send("GET", "/api/v2/posts/" + opts.query(), nil, "", "", list)
+    list.Links = parseLinks(header.Get("Link"))
+    return list, err
+}
+
+func (c *Client) GetPost(postId string) (*BlogPost, error) {
+    post := new(BlogPost)
+    header, err := c.send("GET", "/api/v2/posts/" + url.PathEscape(postId) + "/", nil, "", "", post)
+    post.ETag = header.Get("ETag")
+    return post, err
+}
+
+// Creates a post. Title and Content are required, Slug defaults to the slugified title.
+func (c *Client) CreatePost(input ContentInput) (*BlogPost, error) {
+    post := new(BlogPost)
+    err := c.do("POST", "/api/v2/posts/", input, "", post)
+    return post, err
+}
+
+// Replaces a post. Title, Content and Slug are required, other fields left
+// nil get the values of new posts.
+func (c *Client) UpdatePost(postId string, input ContentInput) (*BlogPost, error) {
+    post := new(BlogPost)
+    header, err := c.send("PUT", "/api/v2/posts/" + url.PathEscape(postId) + "/", input, "", input.IfMatch, post)
+    post.ETag = header.Get("ETag")
+    return post, err
+}
+
+// Changes only the fields set in the input
+func (c *Client) PatchPost(postId string, input ContentInput) (*BlogPost, error) {
+    post := new(BlogPost)
+    header, err := c.send("PATCH", "/api/v2/posts/" + url.PathEscape(postId) + "/", input, "", input.IfMatch, post)
+    post.ETag = header.Get("ETag")
+    return post, err
+}
+
+func (c *Client) DeletePost(postId string) error {
+    return c.do("DELETE", "/api/v2/posts/" + url.PathEscape(postId) + "/", nil, "", nil)
+}
+
+// Runs an operation on several posts. Items fail on their own, see the results.
+func (c *Client) BulkPosts(input BulkInput) (*BulkResults, error) {
+    results := new(BulkResults)
+    err := c.do("POST", "/api/v2/posts/bulk/", input, "", results)
+    return results, err
+}
+
+/* Pages */
+
+func (c *Client) ListPages(opts *ListOptions) (*PageList, error) {
+    list := new(PageList)
+    header, err := c.send("GET", "/api/v2/pages/" + opts.query(), nil, "", "", list)
+    list.Links = parseLinks(header.Get("Link"))
+    return list, err
+}
+
+func (c *Client) GetPage(pageId string) (*Page, error) {
+    page := new(Page)
+    header, err := c.send("GET", "/api/v2/pages/" + url.PathEscape(pageId) + "/", nil, "", "", page)
+    page.ETag = header.Get("ETag")
+    return page, err
+}
+
+// Creates a page. Title, Content and Slug are required.
+func (c *Client) CreatePage(input ContentInput) (*Page, error) {
+    page := new(Page)
+    err := c.do("POST", "/api/v2/pages/", input, "", page)
+    return page, err
+}
+
+// Replaces a page. Title, Content and Slug are required, other fields left
+// nil get the values of new pages.
+func (c *Client) UpdatePage(pageId string, input ContentInput) (*Page, error) {
+    page := new(Page)
+    header, err := c.send("PUT", "/api/v2/pages/" + url.PathEscape(pageId) + "/", input, "", input.IfMatch, page)
+    page.ETag = header.Get("ETag")
+    return page, err
+}
+
+// Changes only the fields set in the input
+func (c *Client) PatchPage(pageId string, input ContentInput) (*Page, error) {
+    page := new(Page)
+    header, err := c.send("PATCH", "/api/v2/pages/" + url.PathEscape(pageId) + "/", input, "", input.IfMatch, page)
+    page.ETag = header.Get("ETag")
+    return page, err
+}
+
+func (c *Client) DeletePage(pageId string) error {
+    return c.do("DELETE", "/api/v2/pages/" + url.PathEscape(pageId) + "/", nil, "", nil)
+}
+
+// Runs an operation on several pages. Items fail on their own, see the results.
+func (c *Client) BulkPages(input BulkInput) (*BulkResults, error) {
+    results := new(BulkResults)
+    err := c.do("POST", "/api/v2/pages/bulk/", input, "", results)
+    return results, err
+}
+
+/* Photos */
+
+// A file to upload
+type PhotoFile struct {
+    Filename string
+    MimeType string
+    Content io.Reader
+}
+
+func (c *Client) ListPhotos(opts *ListOptions) (*PhotoList, error) {
+    list := new(PhotoList)
+    header, err := c.send("GET", "/api/v2/photos/" + opts.query(), nil, "", "", list)
+    list.Links = parseLinks(header.Get("Link"))
+    return list, err
+}
+
+func (c *Client) GetPhoto(photoId string) (*Photo, error) {
+    photo := new(Photo)
+    header, err := c.send("GET", "/api/v2/photos/" + url.PathEscape(photoId) + "/", nil, "", "", photo)
+    photo.ETag = header.Get("ETag")
+    return photo, err
+}
+
+// Uploads the files in a single multipart request
+func (c *Client) UploadPhotos(files ...PhotoFile) ([]Photo, error) {
+    var body bytes.Buffer
+    writer := multipart.NewWriter(&body)
+    for _, file := range files {
+        header := make(textproto.MIMEHeader)
+        header.Set("Content-Disposition", `form-data; name="media"; filename="` + quoteEscaper.Replace(file.Filename) + `"`)
+        header.Set("Content-Type", file.MimeType)
+        part, err := writer.CreatePart(header)
+        if err != nil {
+            return nil, err
+        }
+        if _, err = io.Copy(part, file.Content); err != nil {
+            return nil, err
+        }
+    }
+    if err := writer.Close(); err != nil {
+        return nil, err
+    }
+
+    list := new(PhotoList)
+    err := c.do("POST", "/api/v2/photos/", &body, writer.FormDataContentType(), list)
+    return list.Photos, err
+}
+
+// Replaces tags and publishing of a photo, fields left nil get the values of
+// new photos
+func (c *Client) UpdatePhoto(photoId string, input ContentInput) (*Photo, error) {
+    photo := new(Photo)
+    header, err := c.send("PUT", "/api/v2/photos/" + url.PathEscape(photoId) + "/", input, "", input.IfMatch, photo)
+    photo.ETag = header.Get("ETag")
+    return photo, err
+}
+
+// Changes tags or publishing of a photo, only the fields set in the input
+func (c *Client) PatchPhoto(photoId string, input ContentInput) (*Photo, error) {
+    photo := new(Photo)
+    header, err := c.send("PATCH", "/api/v2/photos/" + url.PathEscape(photoId) + "/", input, "", input.IfMatch, photo)
+    photo.ETag = header.Get("ETag")
+    return photo, err
+}
+
+// Deletes a photo and its file
+func (c *Client) DeletePhoto(photoId string) error {
+    return c.do("DELETE", "/api/v2/photos/" + url.PathEscape(photoId) + "/", nil, "", nil)
+}
+
+/* Menu items */
+
+func (c *Client) ListMenuItems() ([]MenuItem, error) {
+    list := new(menuItemList)
+    err := c.do("GET", "/api/v2/menu-items/", nil, "", list)
+    return list.Items, err
+}
+
+func (c *Client) GetMenuItem(itemId string) (*MenuItem, error) {
+    item := new(MenuItem)
+    err := c.do("GET", "/api/v2/menu-items/" + url.PathEscape(itemId) + "/", nil, "", item)
+    return item, err
+}
+
+// Creates a menu item. Url and Label are required, Id defaults to "menu-" and the slugified label.
+func (c *Client) CreateMenuItem(input MenuItemInput) (*MenuItem, error) {
+    item := new(MenuItem)
+    err := c.do("POST", "/api/v2/menu-items/", input, "", item)
+    return item, err
+}
+
+// Replaces a menu item. Url and Label are required, Position is 0 if nil.
+func (c *Client) UpdateMenuItem(itemId string, input MenuItemInput) (*MenuItem, error) {
+    item := new(MenuItem)
+    err := c.do("PUT", "/api/v2/menu-items/" + url.PathEscape(itemId) + "/", input, "", item)
+    return item, err
+}
+
+// Changes only the fields set in the input
+func (c *Client) PatchMenuItem(itemId string, input MenuItemInput) (*MenuItem, error) {
+    item := new(MenuItem)
+    err := c.do("PATCH", "/api/v2/menu-items/" + url.PathEscape(itemId) + "/", input, "", item)
+    return item, err
+}
+
+func (c *Client) DeleteMenuItem(itemId string) error {
+    return c.do("DELETE", "/api/v2/menu-items/" + url.PathEscape(itemId) + "/", nil, "", nil)
+}
+
+/* Categories */
+
+func (c *Client) ListCategories() ([]Category, error) {
+    list := new(categoryList)
+    err := c.do("GET", "/api/v2/categories/", nil, "", list)
+    return list.Categories, err
+}
+
+func (c *Client) GetCategory(categoryId string) (*Category, error) {
+    category := new(Category)
+    header, err := c.send("GET", "/api/v2/categories/" + url.PathEscape(categoryId) + "/", nil, "", "", category)
+    category.ETag = header.Get("ETag")
+    return category, err
+}
+
+// Creates a category. Name is required, Slug defaults to the slugified name.
+func (c *Client) CreateCategory(input CategoryInput) (*Category, error) {
+    category := new(Category)
+    err := c.do("POST", "/api/v2/categories/", input, "", category)
+    return category, err
+}
+
+// Replaces a category. Name is required.
+func (c *Client) UpdateCategory(categoryId string, input CategoryInput) (*Category, error) {
+    category := new(Category)
+    header, err := c.send("PUT", "/api/v2/categories/" + url.PathEscape(categoryId) + "/", input, "", input.IfMatch, category)
+    category.ETag = header.Get("ETag")
+    return category, err
+}
+
+// Changes only the fields set in the input
+func (c *Client) PatchCategory(categoryId string, input CategoryInput) (*Category, error) {
+    category := new(Category)
+    header, err := c.send("PATCH", "/api/v2/categories/" + url.PathEscape(categoryId) + "/", input, "", input.IfMatch, category)
+    category.ETag = header.Get("ETag")
+    return category, err
+}
+
+// Deletes a category. Its subcategories move to its parent.
+func (c *Client) DeleteCategory(categoryId string) error {
+    return c.do("DELETE", "/api/v2/categories/" + url.PathEscape(categoryId) + "/", nil, "", nil)
+}
+
+// Escapes quotes in multipart header values
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
@@ -0,0 +1,214 @@
+package client
+
+import (
+    "time"
+)
+
+// These types mirror the ones in the cms package, with ids as plain strings
+// so programs using the client don't depend on the database driver.
+
+type BlogPost struct {
+    Id string
+    Slug string
+    Title string
+    Content string
+    Published bool
+    PubDate time.Time
+    Modified time.Time
+    Author string
+    Tags []string
+    TagSlugs []string
+    Categories []string // Category ids
+    OldSlugs []string
+    Seo Seo
+    Summary string
+    Truncated bool
+    WordCount int
+    ReadingTime int // Minutes
+    Permalink string
+    ETag string `json:"-"` // Of the version loaded, see ContentInput.IfMatch
+}
+
+type Page struct {
+    Id string
+    Slug string
+    Title string
+    Content string
+    Published bool
+    PubDate time.Time
+    Modified time.Time
+    Author string
+    Tags []string
+    TagSlugs []string
+    OldSlugs []string
+    Seo Seo
+    ETag string `json:"-"`
+}
+
+// Search engine and social network fields of posts and pages
+type Seo struct {
+    Title string
+    Description string
+    Image string // Photo id
+    Canonical string
+    NoIndex bool
+}
+
+type Photo struct {
+    Id string
+    Filename string
+    MimeType string
+    Published bool
+    PubDate time.Time
+    Modified time.Time
+    Author string
+    Tags []string
+    TagSlugs []string
+    ETag string `json:"-"`
+}
+
+type Category struct {
+    Id string
+    Slug string
+    Name string
+    Description string
+    Parent string // Empty for top level categories
+    Position int
+    Modified time.Time
+    ETag string `json:"-"`
+}
+
+type MenuItem struct {
+    Id string
+    Url string
+    Label string
+    Position int
+}
+
+// Fields sent when creating or updating posts, pages and photos. Nil fields
+// aren't sent, so Patch methods change only the fields that are set.
+type ContentInput struct {
+    Title *string `json:",omitempty"`
+    Content *string `json:",omitempty"`
+    Slug *string `json:",omitempty"`
+    Tags *[]string `json:",omitempty"`
+    Categories *[]string `json:",omitempty"` // Category ids, posts only
+    PubDate *time.Time `json:",omitempty"`
+    Published *bool `json:",omitempty"`
+    Seo *Seo `json:",omitempty"`
+    // Sent as If-Match, like the ETag of the document loaded, so the change
+    // fails with a 412 (see IsConflict) if it was changed since
+    IfMatch string `json:"-"`
+}
+
+// Fields sent when creating or updating menu items
+type MenuItemInput struct {
+    Id *string `json:",omitempty"`
+    Url *string `json:",omitempty"`
+    Label *string `json:",omitempty"`
+    Position *int `json:",omitempty"`
+}
+
+// Fields sent when creating or updating categories
+type CategoryInput struct {
+    Name *string `json:",omitempty"`
+    Slug *string `json:",omitempty"`
+    Description *string `json:",omitempty"`
+    Parent *string `json:",omitempty"` // Empty string moves it to the top level
+    Position *int `json:",omitempty"`
+    IfMatch string `json:"-"` // As in ContentInput
+}
+
+// Operation applied to several posts or pages at once. Action is one of
+// "publish", "unpublish", "delete", "retag" and "author".
+type BulkInput struct {
+    Ids []string
+    Action string
+    Tags *[]string `json:",omitempty"` // Replaces the tags, on retag
+    AddTags []string `json:",omitempty"`
+    RemoveTags []string `json:",omitempty"`
+    Author *string `json:",omitempty"`
+}
+
+type BulkResult struct {
+    Id string `json:"id"`
+    Result string `json:"result"` // "ok" or "error"
+    Message string `json:"message"`
+}
+
+type BulkResults struct {
+    Results []BulkResult `json:"results"`
+    Succeeded int `json:"succeeded"`
+    Failed int `json:"failed"`
+}
+
+// Pagination, filtering and sorting of list methods. Zero values are not sent.
+type ListOptions struct {
+    Page int
+    Limit int
+    Sort string // Like "-pubdate,title"
+    Tag string
+    Category string // Slug, posts only
+    Author string
+    Since time.Time
+    Until time.Time
+    Published string // "true", "false" or "all", for superusers only
+    FullContent bool // Posts only, whose lists have only summaries otherwise
+}
+
+type PostList struct {
+    Posts []BlogPost `json:"posts"`
+    Total int `json:"total"`
+    Page int `json:"page"`
+    Limit int `json:"limit"`
+    Links map[string]string `json:"-"` // Addresses of the "first", "prev", "next" and "last" pages
+}
+
+type PageList struct {
+    Pages []Page `json:"pages"`
+    Total int `json:"total"`
+    Page int `json:"page"`
+    Limit int `json:"limit"`
+    Links map[string]string `json:"-"` // Addresses of the "first", "prev", "next" and "last" pages
+}
+
+type PhotoList struct {
+    Photos []Photo `json:"photos"`
+    Total int `json:"total"`
+    Page int `json:"page"`
+    Limit int `json:"limit"`
+    Links map[string]string `json:"-"` // Addresses of the "first", "prev", "next" and "last" pages
+}
+
+type categoryList struct {
+    Categories []Category `json:"categories"`
+}
+
+type menuItemList struct {
+    Items []MenuItem `json:"items"`
+}
+
+// Helpers to fill the optional fields of inputs
+
+func String(s string) *string {
+    return &s
+}
+
+func Bool(b bool) *bool {
+    return &b
+}
+
+func Int(i int) *int {
+    return &i
+}
+
+func Time(t time.Time) *time.Time {
+    return &t
+}
+
+func Tags(tags ...string) *[]string {
+    if tags == nil {
+        tags = make([]string,0)
+    }
+    return &tags
+}
@@ -1,11 +1,17 @@
 package cms
 
 import (
-    //"log"
+    "log"
     "time"
+    "bytes"
+    "regexp"
+    "errors"
+    "strconv"
     "strings"
+    "unicode"
     "labix.org/v2/mgo"
     "labix.org/v2/mgo/bson"
+    "golang.org/x/text/unicode/norm"
 )
 
 const BLOG_POST_COLL_NAME = "blog_posts"
@@ -16,8 +22,19 @@ type BlogPost struct {
     Content string // In Textile markup format
     Published bool
     PubDate time.Time //bson.MongoTimestamp
+    Modified time.Time
     Author string
     Tags []string
+    TagSlugs []string // Slugs of the tags, in the same order
+    Categories []bson.ObjectId // Ids of the categories the post is in
+    OldSlugs []string // Previous slugs, redirected to the current one
+    Seo SeoFields
+    SearchText string `json:"-"` // Text of the content without markup, for the search index
+    Summary string // Markdown before the more marker, or the first paragraphs
+    Truncated bool // The summary isn't the whole content
+    WordCount int
+    ReadingTime int // Estimated, in minutes
+    Permalink string `bson:"-"` // Filled in when encoded to JSON
 }
 
 const PAGE_COLL_NAME = "pages"
@@ -28,8 +45,13 @@ type Page struct {
     Content string // In Textile markup format
     Published bool
     PubDate time.Time //bson.MongoTimestamp
+    Modified time.Time
     Author string
     Tags []string
+    TagSlugs []string // Slugs of the tags, in the same order
+    OldSlugs []string // Previous slugs, redirected to the current one
+    Seo SeoFields
+    SearchText string `json:"-"` // Text of the content without markup, for the search index
 }
 
 const PHOTO_COLL_NAME = "photos"
@@ -39,37 +61,322 @@ type Photo struct {
     MimeType string
     Published bool
     PubDate time.Time //bson.MongoTimestamp
+    Modified time.Time
     Author string
     Tags []string
+    TagSlugs []string // Slugs of the tags, in the same order
+}
+
+const MENU_ITEM_COLL_NAME = "menu_items"
+type MenuItem struct {
+    Id string `bson:"_id"` // Also used as the HTML element id
+    Url string
+    Label string
+    Position int
+}
+
+// Returned by updates when the document was changed since it was loaded
+var ErrModified = errors.New("Document was modified since it was loaded")
+
+// Returned by updates when the slug belongs to another document
+var ErrSlugTaken = errors.New("Slug is already used by another document")
+
+// Returned by updates when nothing is left of the slug once slugified
+var ErrInvalidSlug = errors.New("Slug must have letters or digits")
+
+// Returns when the post was last changed. Posts saved before modification
+// times were tracked fall back to their publication date.
+func (post BlogPost) LastModified() time.Time {
+    if post.Modified.IsZero() {
+        return post.PubDate
+    }
+    return post.Modified
+}
+
+// Returns when the page was last changed
+func (page Page) LastModified() time.Time {
+    if page.Modified.IsZero() {
+        return page.PubDate
+    }
+    return page.Modified
+}
+
+// Returns when the photo was last changed
+func (photo Photo) LastModified() time.Time {
+    if photo.Modified.IsZero() {
+        return photo.PubDate
+    }
+    return photo.Modified
 }
 
 /* GENERAL */
 
-// Returns the string in small letters, replacing spaces for hifens
+// Letters NFKD doesn't decompose into ASCII ones
+var slugTransliterations = map[rune]string{
+    'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'ł': "l", 'þ': "th", 'ı': "i",
+}
+
+// Returns the string in small ASCII letters and digits, separated by hifens.
+// Accents are removed, and spaces, punctuation and other symbols become a
+// single hifen, so "Ça va, João?" becomes "ca-va-joao".
 func Slugify(s string) string {
-    // TODO: clean letters to NFKD: http://play.golang.org/p/D7hmrTwi-i
-    for strings.Contains(s, "  ") {
-        s = strings.Replace(s, "  ", " ", -1)
+    var slug bytes.Buffer
+    hifen := false
+    for _, r := range strings.ToLower(norm.NFKD.String(s)) {
+        if unicode.Is(unicode.Mn, r) {
+            // Accents left apart by the decomposition
+            continue
+        } else if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+            slug.WriteRune(r)
+        } else if t, ok := slugTransliterations[r]; ok {
+            slug.WriteString(t)
+        } else {
+            if slug.Len() > 0 && !hifen {
+                slug.WriteByte('-')
+                hifen = true
+            }
+            continue
+        }
+        hifen = false
+    }
+    return strings.TrimSuffix(slug.String(), "-")
+}
+
+// Returns the tags with their whitespace collapsed, without duplicates, and
+// their slugs. Tags with the same slug are the same tag, written as the first
+// one; tags without letters or digits are dropped.
+func normalizeTags(tags []string) ([]string, []string) {
+    names := make([]string,0)
+    slugs := make([]string,0)
+    for _, tag := range tags {
+        name := strings.Join(strings.Fields(tag), " ")
+        slug := Slugify(name)
+        if slug == "" {
+            continue
+        }
+        found := false
+        for _, existing := range slugs {
+            if existing == slug {
+                found = true
+                break
+            }
+        }
+        if !found {
+            names = append(names, name)
+            slugs = append(slugs, slug)
+        }
+    }
+    return names, slugs
+}
+
+// Returns the slug, or it with the lowest free suffix (-2, -3...), so it isn't
+// used by any other document of the collection
+func uniqueSlug(coll *mgo.Collection, slug string, id bson.ObjectId) (string, error) {
+    if slug == "" {
+        slug = "untitled"
     }
-    s = strings.Replace(s, " ", "-", -1)
-    return strings.ToLower(s)
+
+    var docs []struct {
+        Slug string
+    }
+    pattern := bson.RegEx{Pattern:"^" + regexp.QuoteMeta(slug) + "(-[0-9]+)?$"}
+    err := coll.Find(bson.M{"slug":pattern, "_id":bson.M{"$ne":id}}).Select(bson.M{"slug":1}).All(&docs)
+    if err != nil {
+        return "", err
+    }
+
+    used := make(map[string]bool)
+    for _, doc := range docs {
+        used[doc.Slug] = true
+    }
+    candidate := slug
+    for n := 2; used[candidate]; n++ {
+        candidate = slug + "-" + strconv.Itoa(n)
+    }
+    return candidate, nil
+}
+
+// Returns ErrSlugTaken if another document of the collection has the slug
+func checkSlugFree(coll *mgo.Collection, slug string, id bson.ObjectId) error {
+    count, err := coll.Find(bson.M{"slug":slug, "_id":bson.M{"$ne":id}}).Count()
+    if err == nil && count > 0 {
+        return ErrSlugTaken
+    }
+    return err
+}
+
+// Adds the previous slug to the history when it changed. The current one is
+// taken out, in case an old slug is being used again.
+func rememberSlug(oldSlugs []string, previous string, current string) []string {
+    history := make([]string,0)
+    for _, slug := range oldSlugs {
+        if slug != current && slug != previous {
+            history = append(history, slug)
+        }
+    }
+    if previous != "" && previous != current {
+        history = append(history, previous)
+    }
+    return history
+}
+
+// Inserts a document with a slug, suffixing the slug if it's taken. Retries
+// when another insert takes the same slug meanwhile.
+func insertWithUniqueSlug(coll *mgo.Collection, slug *string, id bson.ObjectId, doc interface{}) error {
+    base := Slugify(*slug)
+    var err error
+    for attempt := 0; attempt < 3; attempt++ {
+        if *slug, err = uniqueSlug(coll, base, id); err != nil {
+            return err
+        }
+        if err = coll.Insert(doc); !mgo.IsDup(err) {
+            return err
+        }
+    }
+    return err
+}
+
+// Renames duplicated slugs, keeping the oldest document's, so the unique
+// index on them can be created
+func dedupeSlugs(coll *mgo.Collection) error {
+    var groups []struct {
+        Ids []bson.ObjectId `bson:"ids"`
+    }
+    pipeline := []bson.M{
+        {"$sort":bson.M{"pubdate":1}},
+        {"$group":bson.M{"_id":"$slug", "ids":bson.M{"$push":"$_id"}, "count":bson.M{"$sum":1}}},
+        {"$match":bson.M{"count":bson.M{"$gt":1}}},
+    }
+    if err := coll.Pipe(pipeline).All(&groups); err != nil {
+        return err
+    }
+
+    for _, group := range groups {
+        for _, id := range group.Ids[1:] {
+            var doc struct {
+                Slug string
+            }
+            if err := coll.FindId(id).One(&doc); err != nil {
+                return err
+            }
+            slug, err := uniqueSlug(coll, Slugify(doc.Slug), id)
+            if err != nil {
+                return err
+            }
+            if err = coll.UpdateId(id, bson.M{"$set":bson.M{"slug":slug}}); err != nil {
+                return err
+            }
+            log.Printf("Slug %v of %v in %v renamed to %v", doc.Slug, id.Hex(), coll.Name, slug)
+        }
+    }
+    return nil
+}
+
+// Creates the indexes used by list queries, if they don't exist yet, and the
+// default menu items before their collection is created with its index
+func EnsureIndexes(db *mgo.Database) error {
+    if err := SeedMenuItems(db); err != nil {
+        return err
+    }
+
+    indexes := map[string][][]string{
+        BLOG_POST_COLL_NAME: {{"published", "-pubdate"}, {"tagslugs"}, {"categories"}, {"author"}, {"oldslugs"}},
+        PAGE_COLL_NAME: {{"published", "title"}, {"tagslugs"}, {"author"}, {"oldslugs"}},
+        PHOTO_COLL_NAME: {{"published", "-pubdate"}, {"tagslugs"}, {"author"}},
+        MENU_ITEM_COLL_NAME: {{"position"}},
+        WEBHOOK_DELIVERY_COLL_NAME: {{"status", "nextattempt"}, {"hookid", "-created"}},
+    }
+
+    for collName, keys := range indexes {
+        for _, key := range keys {
+            if err := db.C(collName).EnsureIndexKey(key...); err != nil {
+                return err
+            }
+        }
+    }
+
+    // Slugs are unique per content type. Indexes from before that are
+    // replaced, once duplicated slugs are renamed.
+    for _, collName := range []string{BLOG_POST_COLL_NAME, PAGE_COLL_NAME, CATEGORY_COLL_NAME} {
+        coll := db.C(collName)
+        index := mgo.Index{Key:[]string{"slug"}, Unique:true}
+        if coll.EnsureIndex(index) == nil {
+            continue
+        }
+        if err := dedupeSlugs(coll); err != nil {
+            return err
+        }
+        coll.DropIndex("slug")
+        if err := coll.EnsureIndex(index); err != nil {
+            return err
+        }
+    }
+    return ensureSearchIndexes(db)
+}
+
+// Current time with the precision MongoDB stores dates with
+func modificationTime() time.Time {
+    return time.Now().Truncate(time.Millisecond)
+}
+
+// Replaces a document only if its modification time is still the one it was
+// loaded with, so concurrent editors can't silently overwrite each other
+func updateUnmodified(coll *mgo.Collection, id bson.ObjectId, loaded time.Time, doc interface{}) error {
+    selector := bson.M{"_id":id}
+    if loaded.IsZero() {
+        selector["modified"] = bson.M{"$exists":false}
+    } else {
+        selector["modified"] = loaded
+    }
+
+    err := coll.Update(selector, doc)
+    if err == mgo.ErrNotFound {
+        return ErrModified
+    }
+    return err
 }
 
 /* BLOG POSTS */
 
 // Returns a list of blog post instances
-func GetRecentBlogPosts(db *mgo.Database) ([]BlogPost, error) {
+func GetRecentBlogPosts(db *mgo.Database, categorySlug string) ([]BlogPost, error) {
     var blogPosts []BlogPost
     var blogPostColl *mgo.Collection
 
+    // Posts in the category or its subcategories, if given
+    filter := bson.M{"published":true}
+    if categorySlug != "" {
+        ids, err := categoryTreeIds(db, categorySlug)
+        if err != nil {
+            return blogPosts, err
+        }
+        filter["categories"] = bson.M{"$in":ids}
+    }
+
     // Auto Disptach info objects
     blogPostColl = db.C(BLOG_POST_COLL_NAME)
-    query := blogPostColl.Find(bson.M{"published":true}).Sort("-pubdate")
+    query := blogPostColl.Find(filter).Sort("-pubdate")
 
     err := query.All(&blogPosts)
     return blogPosts, err
 }
 
+// Returns a page of blog posts matching the options and the total of matches
+func FindBlogPosts(db *mgo.Database, opts ListOptions) ([]BlogPost, int, error) {
+    blogPosts := make([]BlogPost,0)
+    if opts.Category != "" {
+        ids, err := categoryTreeIds(db, opts.Category)
+        if err == mgo.ErrNotFound {
+            return blogPosts, 0, nil
+        } else if err != nil {
+            return blogPosts, 0, err
+        }
+        opts.categoryIds = ids
+    }
+    total, err := findPaginated(db.C(BLOG_POST_COLL_NAME), opts, &blogPosts)
+    return blogPosts, total, err
+}
+
 // Returns the Id and the error
 func InsertNewBlogPost(db *mgo.Database, post *BlogPost) error {
     var blogPostColl *mgo.Collection
@@ -77,10 +384,26 @@ func InsertNewBlogPost(db *mgo.Database, post *BlogPost) error {
 
     // Default empty fields
     post.Id = bson.NewObjectId()
-    post.PubDate = time.Now()
+    if post.PubDate.IsZero() {
+        post.PubDate = time.Now()
+    }
+    post.Modified = modificationTime()
+    post.Tags, post.TagSlugs = normalizeTags(post.Tags)
+    post.SearchText = plainText(post.Content)
+    summarizePost(post)
+    if err := checkCategories(db, post.Categories); err != nil {
+        return err
+    }
 
-    // Insert
-    err := blogPostColl.Insert(post)
+    // Insert, with a slug no other post has
+    err := insertWithUniqueSlug(blogPostColl, &post.Slug, post.Id, post)
+    if err == nil {
+        contentChanged()
+        triggerWebhooks(db, "post.created", post)
+        if post.Published {
+            triggerWebhooks(db, "post.published", post)
+        }
+    }
 
     return err
 }
@@ -90,8 +413,41 @@ func UpdateBlogPost(db *mgo.Database, post *BlogPost) error {
     var blogPostColl *mgo.Collection
     blogPostColl = db.C(BLOG_POST_COLL_NAME)
 
-    // Insert
-    err := blogPostColl.Update(bson.M{"_id":post.Id}, post)
+    // Publishing is notified apart from other changes
+    stored := BlogPost{}
+    found := blogPostColl.FindId(post.Id).Select(bson.M{"published":1, "slug":1}).One(&stored) == nil
+    wasPublished := found && stored.Published
+
+    post.Slug = Slugify(post.Slug)
+    if post.Slug == "" {
+        return ErrInvalidSlug
+    } else if err := checkSlugFree(blogPostColl, post.Slug, post.Id); err != nil {
+        return err
+    } else if err := checkCategories(db, post.Categories); err != nil {
+        return err
+    }
+
+    post.Tags, post.TagSlugs = normalizeTags(post.Tags)
+    post.SearchText = plainText(post.Content)
+    summarizePost(post)
+    loaded, oldSlugs := post.Modified, post.OldSlugs
+    post.Modified = modificationTime()
+    if found {
+        post.OldSlugs = rememberSlug(post.OldSlugs, stored.Slug, post.Slug)
+    }
+    err := updateUnmodified(blogPostColl, post.Id, loaded, post)
+    if mgo.IsDup(err) {
+        err = ErrSlugTaken
+    }
+    if err != nil {
+        post.Modified, post.OldSlugs = loaded, oldSlugs
+    } else {
+        contentChanged()
+        triggerWebhooks(db, "post.updated", post)
+        if post.Published && !wasPublished {
+            triggerWebhooks(db, "post.published", post)
+        }
+    }
 
     return err
 }
@@ -107,12 +463,58 @@ func GetBlogPost(db *mgo.Database, postId string) (BlogPost,error) {
     return blogPost, err
 }
 
+// Loads and return a blog post from database, by slug
+func GetBlogPostBySlug(db *mgo.Database, slug string) (BlogPost,error) {
+    var blogPostColl *mgo.Collection
+    blogPostColl = db.C(BLOG_POST_COLL_NAME)
+
+    blogPost := BlogPost{}
+    err := blogPostColl.Find(bson.M{"slug":slug}).One(&blogPost)
+
+    return blogPost, err
+}
+
+// Returns the published posts right before and after the post, by publication
+// date. Either is nil when there's none.
+func GetAdjacentBlogPosts(db *mgo.Database, post BlogPost) (*BlogPost, *BlogPost, error) {
+    var blogPostColl *mgo.Collection
+    blogPostColl = db.C(BLOG_POST_COLL_NAME)
+
+    adjacent := func(operator string, sort string) (*BlogPost, error) {
+        other := new(BlogPost)
+        err := blogPostColl.Find(bson.M{"published":true, "pubdate":bson.M{operator:post.PubDate}}).Sort(sort).One(other)
+        if err == mgo.ErrNotFound {
+            return nil, nil
+        }
+        return other, err
+    }
+
+    previous, err := adjacent("$lt", "-pubdate")
+    if err != nil {
+        return nil, nil, err
+    }
+    next, err := adjacent("$gt", "pubdate")
+    return previous, next, err
+}
+
+// Loads the blog post that used to have the slug, the last changed if many did
+func GetBlogPostByOldSlug(db *mgo.Database, slug string) (BlogPost,error) {
+    blogPost := BlogPost{}
+    err := db.C(BLOG_POST_COLL_NAME).Find(bson.M{"oldslugs":slug}).Sort("-modified").One(&blogPost)
+    return blogPost, err
+}
+
 // Loads and return a blog post from database
 func DeleteBlogPost(db *mgo.Database, postId string) error {
     var blogPostColl *mgo.Collection
     blogPostColl = db.C(BLOG_POST_COLL_NAME)
 
-    return blogPostColl.Remove(bson.M{"_id":bson.ObjectIdHex(postId)})
+    err := blogPostColl.Remove(bson.M{"_id":bson.ObjectIdHex(postId)})
+    if err == nil {
+        contentChanged()
+        triggerWebhooks(db, "post.deleted", map[string]string{"Id":postId})
+    }
+    return err
 }
 
 /* PAGES */
@@ -130,6 +532,13 @@ func ListPages(db *mgo.Database) ([]Page, error) {
     return pages, err
 }
 
+// Returns a page of pages matching the options and the total of matches
+func FindPages(db *mgo.Database, opts ListOptions) ([]Page, int, error) {
+    pages := make([]Page,0)
+    total, err := findPaginated(db.C(PAGE_COLL_NAME), opts, &pages)
+    return pages, total, err
+}
+
 // Loads and return a page from database, by ID
 func GetPage(db *mgo.Database, pageId string) (Page,error) {
     var pageColl *mgo.Collection
@@ -152,6 +561,13 @@ func GetPageBySlug(db *mgo.Database, slug string) (Page,error) {
     return page, err
 }
 
+// Loads the page that used to have the slug, the last changed if many did
+func GetPageByOldSlug(db *mgo.Database, slug string) (Page,error) {
+    page := Page{}
+    err := db.C(PAGE_COLL_NAME).Find(bson.M{"oldslugs":slug}).Sort("-modified").One(&page)
+    return page, err
+}
+
 // Returns true if a page is found
 func PageExists(db *mgo.Database, slug string) bool {
     var pageColl *mgo.Collection
@@ -172,10 +588,19 @@ func InsertNewPage(db *mgo.Database, page *Page) error {
 
     // Default empty fields
     page.Id = bson.NewObjectId()
-    page.PubDate = time.Now()
-
-    // Insert
-    err := pageColl.Insert(page)
+    if page.PubDate.IsZero() {
+        page.PubDate = time.Now()
+    }
+    page.Modified = modificationTime()
+    page.Tags, page.TagSlugs = normalizeTags(page.Tags)
+    page.SearchText = plainText(page.Content)
+
+    // Insert, with a slug no other page has
+    err := insertWithUniqueSlug(pageColl, &page.Slug, page.Id, page)
+    if err == nil {
+        contentChanged()
+        triggerWebhooks(db, "page.created", page)
+    }
 
     return err
 }
@@ -185,8 +610,34 @@ func UpdatePage(db *mgo.Database, page *Page) error {
     var pageColl *mgo.Collection
     pageColl = db.C(PAGE_COLL_NAME)
 
-    // Insert
-    err := pageColl.Update(bson.M{"_id":page.Id}, page)
+    page.Slug = Slugify(page.Slug)
+    if page.Slug == "" {
+        return ErrInvalidSlug
+    } else if err := checkSlugFree(pageColl, page.Slug, page.Id); err != nil {
+        return err
+    }
+
+    // Keeps the previous slug to redirect from
+    stored := Page{}
+    found := pageColl.FindId(page.Id).Select(bson.M{"slug":1}).One(&stored) == nil
+
+    page.Tags, page.TagSlugs = normalizeTags(page.Tags)
+    page.SearchText = plainText(page.Content)
+    loaded, oldSlugs := page.Modified, page.OldSlugs
+    page.Modified = modificationTime()
+    if found {
+        page.OldSlugs = rememberSlug(page.OldSlugs, stored.Slug, page.Slug)
+    }
+    err := updateUnmodified(pageColl, page.Id, loaded, page)
+    if mgo.IsDup(err) {
+        err = ErrSlugTaken
+    }
+    if err != nil {
+        page.Modified, page.OldSlugs = loaded, oldSlugs
+    } else {
+        contentChanged()
+        triggerWebhooks(db, "page.updated", page)
+    }
 
     return err
 }
@@ -196,7 +647,12 @@ func DeletePage(db *mgo.Database, pageId string) error {
     var pageColl *mgo.Collection
     pageColl = db.C(PAGE_COLL_NAME)
 
-    return pageColl.Remove(bson.M{"_id":bson.ObjectIdHex(pageId)})
+    err := pageColl.Remove(bson.M{"_id":bson.ObjectIdHex(pageId)})
+    if err == nil {
+        contentChanged()
+        triggerWebhooks(db, "page.deleted", map[string]string{"Id":pageId})
+    }
+    return err
 }
 
 /* PHOTOS */
@@ -209,9 +665,15 @@ func InsertNewPhoto(db *mgo.Database, photo *Photo) error {
     // Default empty fields
     photo.Id = bson.NewObjectId()
     photo.PubDate = time.Now()
+    photo.Modified = modificationTime()
+    photo.Tags, photo.TagSlugs = normalizeTags(photo.Tags)
 
     // Insert
     err := photoColl.Insert(photo)
+    if err == nil {
+        contentChanged()
+        triggerWebhooks(db, "photo.created", photo)
+    }
 
     return err
 }
@@ -229,3 +691,146 @@ func ListPhotos(db *mgo.Database) ([]Photo, error) {
     return photos, err
 }
 
+
+// Returns a page of photos matching the options and the total of matches
+func FindPhotos(db *mgo.Database, opts ListOptions) ([]Photo, int, error) {
+    photos := make([]Photo,0)
+    total, err := findPaginated(db.C(PHOTO_COLL_NAME), opts, &photos)
+    return photos, total, err
+}
+
+// Returns the published photos having any of the tags
+func ListPhotosByTags(db *mgo.Database, tags []string) ([]Photo, error) {
+    photos := make([]Photo,0)
+    if len(tags) == 0 {
+        return photos, nil
+    }
+
+    slugs := make([]string,0)
+    for _, tag := range tags {
+        slugs = append(slugs, Slugify(tag))
+    }
+    query := db.C(PHOTO_COLL_NAME).Find(bson.M{"published":true, "tagslugs":bson.M{"$in":slugs}}).Sort("-pubdate")
+    err := query.All(&photos)
+    return photos, err
+}
+
+// Loads and return a photo from database
+func GetPhoto(db *mgo.Database, photoId string) (Photo,error) {
+    var photoColl *mgo.Collection
+    photoColl = db.C(PHOTO_COLL_NAME)
+
+    photo := Photo{}
+    err := photoColl.Find(bson.M{"_id":bson.ObjectIdHex(photoId)}).One(&photo)
+
+    return photo, err
+}
+
+// Updates an existing photo
+func UpdatePhoto(db *mgo.Database, photo *Photo) error {
+    var photoColl *mgo.Collection
+    photoColl = db.C(PHOTO_COLL_NAME)
+
+    photo.Tags, photo.TagSlugs = normalizeTags(photo.Tags)
+    loaded := photo.Modified
+    photo.Modified = modificationTime()
+    err := updateUnmodified(photoColl, photo.Id, loaded, photo)
+    if err != nil {
+        photo.Modified = loaded
+    } else {
+        contentChanged()
+        triggerWebhooks(db, "photo.updated", photo)
+    }
+
+    return err
+}
+
+// Removes a photo from database
+func DeletePhoto(db *mgo.Database, photoId string) error {
+    var photoColl *mgo.Collection
+    photoColl = db.C(PHOTO_COLL_NAME)
+
+    err := photoColl.Remove(bson.M{"_id":bson.ObjectIdHex(photoId)})
+    if err == nil {
+        contentChanged()
+        triggerWebhooks(db, "photo.deleted", map[string]string{"Id":photoId})
+    }
+    return err
+}
+
+/* MENU ITEMS */
+
+// Menu items the site starts with
+func defaultMenuItems() []MenuItem {
+    menuItemsList := make([]MenuItem,0)
+    menuItemsList = append(menuItemsList, MenuItem{Url:"/", Id:"menu-home", Label:"Home", Position:1})
+    menuItemsList = append(menuItemsList, MenuItem{Url:"/real-life/", Id:"menu-life", Label:"Real life", Position:2})
+    menuItemsList = append(menuItemsList, MenuItem{Url:"/legacy/", Id:"menu-legacy", Label:"Legacy", Position:3})
+    menuItemsList = append(menuItemsList, MenuItem{Url:"http://github.com/marinho", Id:"menu-github", Label:"Github", Position:4})
+    menuItemsList = append(menuItemsList, MenuItem{Url:"http://old.marinhobrandao.com/", Id:"menu-old", Label:"Old site", Position:5})
+    menuItemsList = append(menuItemsList, MenuItem{Url:"https://plus.google.com/108430754321695774288/posts", Id:"menu-gplus", Label:"Google+", Position:6})
+    menuItemsList = append(menuItemsList, MenuItem{Url:"http://de.linkedin.com/in/marinhobrandao", Id:"menu-linkedin", Label:"Linkedin", Position:7})
+    return menuItemsList
+}
+
+// Saves the default menu items when the collection doesn't exist yet, so
+// they can be edited like any other. Items deleted later aren't restored.
+func SeedMenuItems(db *mgo.Database) error {
+    names, err := db.CollectionNames()
+    if err != nil || containsString(names, MENU_ITEM_COLL_NAME) {
+        return err
+    }
+    for _, item := range defaultMenuItems() {
+        if err = InsertNewMenuItem(db, &item); err != nil && !mgo.IsDup(err) {
+            return err
+        }
+    }
+    return nil
+}
+
+// Returns the menu items, ordered by position
+func ListMenuItems(db *mgo.Database) ([]MenuItem, error) {
+    menuItems := make([]MenuItem,0)
+    var menuItemColl *mgo.Collection
+
+    menuItemColl = db.C(MENU_ITEM_COLL_NAME)
+    query := menuItemColl.Find(nil).Sort("position")
+
+    err := query.All(&menuItems)
+    return menuItems, err
+}
+
+// Loads and return a menu item from database
+func GetMenuItem(db *mgo.Database, itemId string) (MenuItem,error) {
+    var menuItemColl *mgo.Collection
+    menuItemColl = db.C(MENU_ITEM_COLL_NAME)
+
+    menuItem := MenuItem{}
+    err := menuItemColl.Find(bson.M{"_id":itemId}).One(&menuItem)
+
+    return menuItem, err
+}
+
+// Inserts a new menu item
+func InsertNewMenuItem(db *mgo.Database, item *MenuItem) error {
+    var menuItemColl *mgo.Collection
+    menuItemColl = db.C(MENU_ITEM_COLL_NAME)
+
+    return menuItemColl.Insert(item)
+}
+
+// Updates an existing menu item
+func UpdateMenuItem(db *mgo.Database, item *MenuItem) error {
+    var menuItemColl *mgo.Collection
+    menuItemColl = db.C(MENU_ITEM_COLL_NAME)
+
+    return menuItemColl.Update(bson.M{"_id":item.Id}, item)
+}
+
+// Removes a menu item from database
+func DeleteMenuItem(db *mgo.Database, itemId string) error {
+    var menuItemColl *mgo.Collection
+    menuItemColl = db.C(MENU_ITEM_COLL_NAME)
+
+    return menuItemColl.Remove(bson.M{"_id":itemId})
+}
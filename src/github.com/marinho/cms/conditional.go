@@ -0,0 +1,81 @@
+package cms
+
+import (
+    "fmt"
+    "time"
+    "strconv"
+    "strings"
+    "net/http"
+    "crypto/md5"
+    "labix.org/v2/mgo/bson"
+)
+
+// Returns the entity tag of a document, derived from its id and modification time
+func documentTag(id bson.ObjectId, modified time.Time) string {
+    return "\"" + id.Hex() + "-" + strconv.FormatInt(modified.UnixNano(), 36) + "\""
+}
+
+// Returns the entity tag of a response body
+func contentTag(data []byte) string {
+    return fmt.Sprintf("\"%x\"", md5.Sum(data))
+}
+
+// Returns true if the header value (a list of tags or "*") matches the tag.
+// Weak tags are compared by their opaque value.
+func tagMatches(header string, tag string) bool {
+    for _, item := range strings.Split(header, ",") {
+        item = strings.TrimSpace(item)
+        if item == "*" || strings.TrimPrefix(item, "W/") == tag {
+            return true
+        }
+    }
+    return false
+}
+
+// Sets ETag and Last-Modified headers and answers with 304 if the client
+// already has this version. Returns true when the response was written.
+func checkNotModified(c http.ResponseWriter, req *http.Request, tag string, modified time.Time) bool {
+    if req.Method != "GET" && req.Method != "HEAD" {
+        return false
+    }
+
+    c.Header().Set("ETag", tag)
+    if !modified.IsZero() {
+        c.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+    }
+
+    notModified := false
+    if header := req.Header.Get("If-None-Match"); header != "" {
+        notModified = tagMatches(header, tag)
+    } else if header := req.Header.Get("If-Modified-Since"); header != "" && !modified.IsZero() {
+        since, err := http.ParseTime(header)
+        notModified = err == nil && !modified.Truncate(time.Second).After(since)
+    }
+
+    if notModified {
+        c.WriteHeader(http.StatusNotModified)
+    }
+    return notModified
+}
+
+// Returns false and answers with 412 when the request's If-Match header
+// doesn't match the current version of the document
+func checkIfMatch(c http.ResponseWriter, req *http.Request, tag string) bool {
+    header := req.Header.Get("If-Match")
+    if header != "" && !tagMatches(header, tag) {
+        http.Error(c, "Precondition failed: the document was changed by someone else", http.StatusPreconditionFailed)
+        return false
+    }
+    return true
+}
+
+// Writes a response body, unless the client already has it. Lists pass a zero
+// modification time, as deletions wouldn't be noticed through Last-Modified.
+func writeCached(c http.ResponseWriter, req *http.Request, data string, modified time.Time) {
+    if checkNotModified(c, req, contentTag([]byte(data)), modified) {
+        return
+    }
+
+    c.Header().Add("Content-Length", strconv.Itoa(len(data)))
+    c.Write([]byte(data))
+}
@@ -0,0 +1,155 @@
+package cms
+
+import (
+    "errors"
+    "strings"
+    "strconv"
+    "net/http"
+)
+
+// Cross-origin access to a group of routes. Without AllowedOrigins no CORS
+// headers are sent, so browsers only allow same-origin requests.
+type CorsPolicy struct {
+    AllowedOrigins []string // Like "https://example.com", or "*" for any
+    AllowedMethods []string // Defaults to the group's methods
+    AllowedHeaders []string // Defaults to defaultCorsHeaders
+    ExposedHeaders []string // Defaults to defaultCorsExposedHeaders
+    AllowCredentials bool // Cookies and the Authorization header
+    MaxAge int // Seconds browsers may cache preflight responses
+}
+
+var publicCorsMethods = []string{"GET", "HEAD"}
+var adminCorsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+var defaultCorsHeaders = []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", "If-Modified-Since"}
+var defaultCorsExposedHeaders = []string{"ETag", "Last-Modified", "Link", "Location", "X-Total-Count"}
+
+// Returns the policy and the default methods of the route group of the path,
+// nil for routes not shared with other origins. Changes through the content
+// APIs belong to the admin group, reads to the public one. GraphQL sent with
+// POST, which may carry mutations, is a change too; other origins read it
+// with GET.
+func corsPolicyFor(path string, method string) (*CorsPolicy, []string) {
+    switch {
+    case strings.HasPrefix(path, "/api/admin/"), path == "/login/", path == "/logout/", path == "/api/is-superuser/":
+        return &systemConf.AdminCors, adminCorsMethods
+    case strings.HasPrefix(path, "/api/"):
+        if method == "GET" || method == "HEAD" {
+            return &systemConf.PublicCors, publicCorsMethods
+        }
+        return &systemConf.AdminCors, adminCorsMethods
+    }
+    return nil, nil
+}
+
+// Returns an error if the policy would share credentials with any origin
+func (policy *CorsPolicy) check() error {
+    if policy.AllowCredentials && containsString(policy.AllowedOrigins, "*") {
+        return errors.New("AllowedOrigins can't have \"*\" when AllowCredentials is true")
+    }
+    return nil
+}
+
+// Returns the value of Access-Control-Allow-Origin for the origin, or an
+// empty string if it isn't allowed
+func (policy *CorsPolicy) allowedOrigin(origin string) string {
+    for _, allowed := range policy.AllowedOrigins {
+        if allowed == "*" {
+            // Credentials are only shared with the origins listed
+            if !policy.AllowCredentials {
+                return "*"
+            }
+        } else if strings.EqualFold(allowed, origin) {
+            return origin
+        }
+    }
+    return ""
+}
+
+// Returns true if every item is in the list, ignoring case
+func allowsAll(list []string, items []string) bool {
+    for _, item := range items {
+        found := false
+        for _, allowed := range list {
+            if strings.EqualFold(allowed, item) {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return false
+        }
+    }
+    return true
+}
+
+// Wraps the router, answering preflight requests before they're routed and
+// adding CORS headers to the responses
+func CorsMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(c http.ResponseWriter, req *http.Request) {
+        origin := req.Header.Get("Origin")
+        requestMethod := req.Header.Get("Access-Control-Request-Method")
+        preflight := req.Method == "OPTIONS" && requestMethod != ""
+        if origin == "" {
+            next.ServeHTTP(c, req)
+            return
+        }
+
+        method := req.Method
+        if preflight {
+            method = requestMethod
+        }
+        policy, methods := corsPolicyFor(req.URL.Path, method)
+        if policy == nil {
+            next.ServeHTTP(c, req)
+            return
+        }
+        c.Header().Add("Vary", "Origin")
+
+        if len(policy.AllowedMethods) > 0 {
+            methods = policy.AllowedMethods
+        }
+        headers := policy.AllowedHeaders
+        if len(headers) == 0 {
+            headers = defaultCorsHeaders
+        }
+        exposed := policy.ExposedHeaders
+        if len(exposed) == 0 {
+            exposed = defaultCorsExposedHeaders
+        }
+        allowedOrigin := policy.allowedOrigin(origin)
+
+        if preflight {
+            var requestHeaders []string
+            for _, header := range strings.Split(req.Header.Get("Access-Control-Request-Headers"), ",") {
+                if strings.TrimSpace(header) != "" {
+                    requestHeaders = append(requestHeaders, strings.TrimSpace(header))
+                }
+            }
+            if allowedOrigin == "" || !allowsAll(methods, []string{requestMethod}) || !allowsAll(headers, requestHeaders) {
+                http.Error(c, "Cross-origin request not allowed", http.StatusForbidden)
+                return
+            }
+
+            c.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+            c.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+            c.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+            if policy.AllowCredentials {
+                c.Header().Set("Access-Control-Allow-Credentials", "true")
+            }
+            if policy.MaxAge > 0 {
+                c.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+            }
+            c.WriteHeader(http.StatusNoContent)
+            return
+        }
+
+        if allowedOrigin != "" && allowsAll(methods, []string{req.Method}) {
+            c.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+            c.Header().Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+            if policy.AllowCredentials {
+                c.Header().Set("Access-Control-Allow-Credentials", "true")
+            }
+        }
+        next.ServeHTTP(c, req)
+    })
+}
@@ -0,0 +1,81 @@
+package cms
+
+import (
+    "strings"
+    "net/http"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+// Splits the summary of a post from the rest of its content
+const MORE_MARKER = "<!--more-->"
+
+// Words the automatic summary has at least, in whole paragraphs
+const SUMMARY_WORDS = 55
+
+// Reading speed used to estimate reading times
+const WORDS_PER_MINUTE = 200
+
+// Returns the summary of content in Markdown: what comes before the more
+// marker, or else its first paragraphs. Truncated is false when the summary is
+// the whole content.
+func summarize(content string) (summary string, truncated bool) {
+    if i := strings.Index(content, MORE_MARKER); i >= 0 {
+        return strings.TrimSpace(content[:i]), strings.TrimSpace(content[i+len(MORE_MARKER):]) != ""
+    }
+
+    content = strings.TrimSpace(strings.Replace(content, "\r\n", "\n", -1))
+    paragraphs := strings.Split(content, "\n\n")
+    words := 0
+    for i, paragraph := range paragraphs {
+        words += len(strings.Fields(plainText(paragraph)))
+        if words >= SUMMARY_WORDS {
+            // Paragraphs inside fenced code would be split, so those are kept whole
+            summary = strings.Join(paragraphs[:i+1], "\n\n")
+            if strings.Count(summary, "```") % 2 == 0 {
+                return summary, i < len(paragraphs) - 1
+            }
+        }
+    }
+    return content, false
+}
+
+// Fills the summary, word count and reading time of a post from its content
+func summarizePost(post *BlogPost) {
+    // Lists render summaries in the browser, where shortcodes would show as
+    // written
+    post.Summary, post.Truncated = summarize(post.Content)
+    post.Summary = strings.TrimSpace(stripShortcodes(post.Summary))
+    post.WordCount = len(strings.Fields(plainText(post.Content)))
+    post.ReadingTime = (post.WordCount + WORDS_PER_MINUTE - 1) / WORDS_PER_MINUTE
+    if post.ReadingTime < 1 {
+        post.ReadingTime = 1
+    }
+}
+
+// Fills the summaries of posts saved before summaries existed
+func SummarizeStoredPosts(db *mgo.Database) error {
+    coll := db.C(BLOG_POST_COLL_NAME)
+    var post BlogPost
+    iter := coll.Find(bson.M{"wordcount":bson.M{"$exists":false}}).Select(bson.M{"content":1}).Iter()
+    for iter.Next(&post) {
+        summarizePost(&post)
+        change := bson.M{"summary":post.Summary, "truncated":post.Truncated, "wordcount":post.WordCount, "readingtime":post.ReadingTime}
+        if err := coll.UpdateId(post.Id, bson.M{"$set":change}); err != nil {
+            iter.Close()
+            return err
+        }
+    }
+    return iter.Close()
+}
+
+// Leaves the content out of posts in lists, which show their summaries, unless
+// the request asks for it with content=full
+func excerptPosts(req *http.Request, posts []BlogPost) {
+    if req.URL.Query().Get("content") == "full" {
+        return
+    }
+    for i := range posts {
+        posts[i].Content = ""
+    }
+}
@@ -0,0 +1,416 @@
+package cms
+
+import (
+    "os"
+    "fmt"
+    "bytes"
+    "log"
+    "time"
+    "regexp"
+    "strconv"
+    "net/url"
+    "net/http"
+    "path/filepath"
+    "encoding/xml"
+    "encoding/json"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+const FEED_ITEMS = 20
+
+// Updated time of feeds without posts
+var feedsStarted = time.Now()
+
+var relativeLinkPattern = regexp.MustCompile(`(\s(?:href|src)=")/([^/])`)
+
+// Published posts of a feed, newest first, and what the feed is about
+type feedInfo struct {
+    Title string
+    Description string
+    Link string // Absolute address of the page the feed follows
+    Self string // Absolute address of the feed, without its extension
+    Posts []BlogPost
+    Updated time.Time // Latest modification of its posts
+    Page int
+    Total int // Posts in every page
+}
+
+// Returns the scheme and host the site is served at, from the configuration
+// or else from the request
+func siteUrl(req *http.Request) string {
+    if systemConf.SiteUrl != "" {
+        return systemConf.SiteUrl
+    }
+    scheme := "http"
+    if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+        scheme = "https"
+    }
+    return scheme + "://" + req.Host
+}
+
+// Returns the title of the site, from the configuration or else its host
+func siteTitle(req *http.Request) string {
+    if systemConf.SiteTitle != "" {
+        return systemConf.SiteTitle
+    }
+    return req.Host
+}
+
+// Loads a page of the feed of the request: all posts, or the ones with the tag
+// or in the category of the route. Returns mgo.ErrNotFound for unknown tags
+// and categories.
+func loadFeed(db *mgo.Database, req *http.Request, page int) (feedInfo, error) {
+    base := siteUrl(req)
+    published := true
+    opts := ListOptions{Page:page, Limit:FEED_ITEMS, Sort:[]string{"-pubdate"}, Published:&published}
+    feed := feedInfo{Title:siteTitle(req), Link:base + "/", Self:base + "/feed", Page:page}
+    feed.Description = "Latest posts of " + feed.Title
+
+    args := mux.Vars(req)
+    if args["tagSlug"] != "" {
+        tag, err := GetTag(db, args["tagSlug"], false)
+        if err != nil {
+            return feed, err
+        }
+        opts.Tag = tag.Slug
+        feed.Title += ": " + tag.Name
+        feed.Description = "Latest posts tagged " + tag.Name
+        feed.Link = base + "/tag/" + tag.Slug + "/"
+        feed.Self = feed.Link + "feed"
+    } else if args["categorySlug"] != "" {
+        category, err := GetCategoryBySlug(db, args["categorySlug"])
+        if err != nil {
+            return feed, err
+        }
+        opts.Category = category.Slug
+        feed.Updated = category.LastModified()
+        feed.Title += ": " + category.Name
+        feed.Description = "Latest posts in " + category.Name
+        feed.Link = base + category.Url()
+        feed.Self = feed.Link + "feed"
+    }
+
+    posts, total, err := FindBlogPosts(db, opts)
+    feed.Posts = posts
+    feed.Total = total
+    for _, post := range posts {
+        if post.LastModified().After(feed.Updated) {
+            feed.Updated = post.LastModified()
+        }
+    }
+    if feed.Updated.IsZero() {
+        feed.Updated = feedsStarted
+    }
+    return feed, err
+}
+
+// Stable id of a post in feeds, which its permalink isn't as slugs change.
+// Its host is the configured site's, so it's the same however the feed is
+// requested.
+func feedEntryId(req *http.Request, post BlogPost) string {
+    host := req.Host
+    if site, err := url.Parse(siteUrl(req)); err == nil && site.Host != "" {
+        host = site.Host
+    }
+    return fmt.Sprintf("tag:%v,%v:%v", host, post.Id.Time().UTC().Format("2006-01-02"), post.Id.Hex())
+}
+
+// Renders the content of a post for feeds, with links to the site absolute,
+// as feed readers show it elsewhere
+func feedContent(db *mgo.Database, req *http.Request, post BlogPost) string {
+    body := string(renderPublicContent(db, post.Content))
+    return relativeLinkPattern.ReplaceAllString(body, "${1}" + siteUrl(req) + "/${2}")
+}
+
+/* Atom */
+
+type atomFeed struct {
+    XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+    Title string `xml:"title"`
+    Subtitle string `xml:"subtitle"`
+    Id string `xml:"id"`
+    Updated string `xml:"updated"`
+    Links []atomLink `xml:"link"`
+    Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+    Rel string `xml:"rel,attr,omitempty"`
+    Type string `xml:"type,attr,omitempty"`
+    Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+    Title string `xml:"title"`
+    Id string `xml:"id"`
+    Published string `xml:"published"`
+    Updated string `xml:"updated"`
+    Link atomLink `xml:"link"`
+    Author atomAuthor `xml:"author"`
+    Categories []atomCategory `xml:"category"`
+    Content atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+    Name string `xml:"name"`
+}
+
+type atomCategory struct {
+    Term string `xml:"term,attr"`
+    Label string `xml:"label,attr,omitempty"`
+}
+
+type atomContent struct {
+    Type string `xml:"type,attr"`
+    Body string `xml:",chardata"`
+}
+
+// Builds the Atom document of a feed
+func atomDocument(db *mgo.Database, req *http.Request, feed feedInfo) atomFeed {
+    doc := atomFeed{Title:feed.Title, Subtitle:feed.Description, Id:feed.Self + ".atom",
+        Updated:feed.Updated.UTC().Format(time.RFC3339),
+        Links:[]atomLink{{Rel:"self", Type:"application/atom+xml", Href:feed.Self + ".atom"}, {Rel:"alternate", Type:"text/html", Href:feed.Link}},
+        Entries:make([]atomEntry,0)}
+
+    for _, post := range feed.Posts {
+        entry := atomEntry{Title:post.Title, Id:feedEntryId(req, post),
+            Published:post.PubDate.UTC().Format(time.RFC3339), Updated:post.LastModified().UTC().Format(time.RFC3339),
+            Link:atomLink{Rel:"alternate", Type:"text/html", Href:siteUrl(req) + post.Url()},
+            Author:atomAuthor{Name:post.Author},
+            Content:atomContent{Type:"html", Body:feedContent(db, req, post)}}
+        for _, tag := range tagLinks(post.Tags, post.TagSlugs) {
+            entry.Categories = append(entry.Categories, atomCategory{Term:tag.Slug, Label:tag.Name})
+        }
+        doc.Entries = append(doc.Entries, entry)
+    }
+    return doc
+}
+
+/* RSS 2.0 */
+
+type rssFeed struct {
+    XMLName xml.Name `xml:"rss"`
+    Version string `xml:"version,attr"`
+    AtomNamespace string `xml:"xmlns:atom,attr"`
+    Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+    Title string `xml:"title"`
+    Link string `xml:"link"`
+    Description string `xml:"description"`
+    Self atomLink `xml:"atom:link"`
+    LastBuildDate string `xml:"lastBuildDate"`
+    Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+    Title string `xml:"title"`
+    Link string `xml:"link"`
+    Guid rssGuid `xml:"guid"`
+    PubDate string `xml:"pubDate"`
+    Categories []string `xml:"category"`
+    Description string `xml:"description"`
+}
+
+type rssGuid struct {
+    IsPermaLink bool `xml:"isPermaLink,attr"`
+    Id string `xml:",chardata"`
+}
+
+// Builds the RSS document of a feed
+func rssDocument(db *mgo.Database, req *http.Request, feed feedInfo) rssFeed {
+    channel := rssChannel{Title:feed.Title, Link:feed.Link, Description:feed.Description,
+        Self:atomLink{Rel:"self", Type:"application/rss+xml", Href:feed.Self + ".rss"},
+        LastBuildDate:feed.Updated.UTC().Format(time.RFC1123Z), Items:make([]rssItem,0)}
+
+    for _, post := range feed.Posts {
+        item := rssItem{Title:post.Title, Link:siteUrl(req) + post.Url(),
+            Guid:rssGuid{IsPermaLink:false, Id:feedEntryId(req, post)},
+            PubDate:post.PubDate.UTC().Format(time.RFC1123Z), Categories:post.Tags,
+            Description:feedContent(db, req, post)}
+        channel.Items = append(channel.Items, item)
+    }
+    return rssFeed{Version:"2.0", AtomNamespace:"http://www.w3.org/2005/Atom", Channel:channel}
+}
+
+/* JSON Feed 1.1 */
+
+type jsonFeed struct {
+    Version string `json:"version"`
+    Title string `json:"title"`
+    HomePageUrl string `json:"home_page_url"`
+    FeedUrl string `json:"feed_url"`
+    Description string `json:"description,omitempty"`
+    NextUrl string `json:"next_url,omitempty"`
+    Items []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+    Id string `json:"id"`
+    Url string `json:"url"`
+    Title string `json:"title"`
+    ContentHtml string `json:"content_html"`
+    DatePublished string `json:"date_published"`
+    DateModified string `json:"date_modified"`
+    Tags []string `json:"tags,omitempty"`
+    Authors []jsonFeedAuthor `json:"authors,omitempty"`
+    Attachments []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+    Name string `json:"name"`
+}
+
+type jsonFeedAttachment struct {
+    Url string `json:"url"`
+    MimeType string `json:"mime_type"`
+    SizeInBytes int64 `json:"size_in_bytes,omitempty"`
+}
+
+var photoUrlPattern = regexp.MustCompile(`/static/photos/([^\s"'()<>]+)`)
+
+// Returns the published photos the content shows, from their addresses in it,
+// in no particular order
+func contentPhotos(db *mgo.Database, content string) ([]Photo, error) {
+    photos := make([]Photo,0)
+    filenames := make([]string,0)
+    for _, match := range photoUrlPattern.FindAllStringSubmatch(content, -1) {
+        if !containsString(filenames, match[1]) {
+            filenames = append(filenames, match[1])
+        }
+    }
+    if len(filenames) == 0 {
+        return photos, nil
+    }
+    err := db.C(PHOTO_COLL_NAME).Find(bson.M{"published":true, "filename":bson.M{"$in":filenames}}).All(&photos)
+    return photos, err
+}
+
+// Builds the JSON Feed document of a feed, with the photos of posts as
+// attachments and the address of the next page if there is one
+func jsonFeedDocument(db *mgo.Database, req *http.Request, feed feedInfo) (jsonFeed, error) {
+    doc := jsonFeed{Version:"https://jsonfeed.org/version/1.1", Title:feed.Title, HomePageUrl:feed.Link,
+        FeedUrl:feed.Self + ".json", Description:feed.Description, Items:make([]jsonFeedItem,0)}
+    if feed.Page * FEED_ITEMS < feed.Total {
+        doc.NextUrl = siteUrl(req) + pageUrl(req, feed.Page + 1)
+    }
+
+    for _, post := range feed.Posts {
+        item := jsonFeedItem{Id:feedEntryId(req, post), Url:siteUrl(req) + post.Url(), Title:post.Title,
+            ContentHtml:feedContent(db, req, post),
+            DatePublished:post.PubDate.UTC().Format(time.RFC3339), DateModified:post.LastModified().UTC().Format(time.RFC3339),
+            Tags:post.Tags}
+        if post.Author != "" {
+            item.Authors = []jsonFeedAuthor{{Name:post.Author}}
+        }
+
+        photos, err := contentPhotos(db, post.Content)
+        if err != nil {
+            return doc, err
+        }
+        for _, photo := range photos {
+            attachment := jsonFeedAttachment{Url:siteUrl(req) + "/static/photos/" + photo.Filename, MimeType:photo.MimeType}
+            if info, err := os.Stat(filepath.Join(systemConf.PhotosRoot, photo.Filename)); err == nil {
+                attachment.SizeInBytes = info.Size()
+            }
+            item.Attachments = append(item.Attachments, attachment)
+        }
+        doc.Items = append(doc.Items, item)
+    }
+    return doc, nil
+}
+
+/* HANDLERS */
+
+// Encodes a feed document and writes it, unless the client has it already.
+// Its ETag changes with the content, so removed posts are noticed too.
+func writeFeed(c http.ResponseWriter, req *http.Request, contentType string, doc interface{}) {
+    b, err := xml.MarshalIndent(doc, "", "  ")
+    if err != nil {
+        log.Println(err)
+        http.Error(c, "Server error", http.StatusInternalServerError)
+        return
+    }
+    c.Header().Set("Content-Type", contentType + "; charset=utf-8")
+    writeCached(c, req, xml.Header + string(b), time.Time{})
+}
+
+// Loads the feed of the request, answering with an error if it can't
+func serveFeed(c http.ResponseWriter, req *http.Request, page int) (feedInfo, bool) {
+    log.Println(req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        http.Error(c, "Invalid method.", http.StatusMethodNotAllowed)
+        return feedInfo{}, false
+    }
+
+    feed, err := loadFeed(dbDefaultConn.DB(systemConf.DBName), req, page)
+    if err == mgo.ErrNotFound {
+        http.Error(c, "Not found", http.StatusNotFound)
+        return feed, false
+    } else if err != nil {
+        log.Println(err)
+        http.Error(c, "Server error", http.StatusInternalServerError)
+        return feed, false
+    }
+    return feed, true
+}
+
+// Atom feed of the latest posts, of all or of a tag or category
+func AtomFeedHandler(c http.ResponseWriter, req *http.Request) {
+    if feed, ok := serveFeed(c, req, 1); ok {
+        writeFeed(c, req, "application/atom+xml", atomDocument(dbDefaultConn.DB(systemConf.DBName), req, feed))
+    }
+}
+
+// RSS feed of the latest posts, of all or of a tag or category
+func RssFeedHandler(c http.ResponseWriter, req *http.Request) {
+    if feed, ok := serveFeed(c, req, 1); ok {
+        writeFeed(c, req, "application/rss+xml", rssDocument(dbDefaultConn.DB(systemConf.DBName), req, feed))
+    }
+}
+
+// JSON Feed of the published posts, of all or of a tag or category, paginated
+// with the page argument
+func JsonFeedHandler(c http.ResponseWriter, req *http.Request) {
+    page := 1
+    if value := req.URL.Query().Get("page"); value != "" {
+        var err error
+        if page, err = strconv.Atoi(value); err != nil || page < 1 {
+            http.Error(c, "page must be a positive number", http.StatusBadRequest)
+            return
+        }
+    }
+
+    feed, ok := serveFeed(c, req, page)
+    if !ok {
+        return
+    }
+    doc, err := jsonFeedDocument(dbDefaultConn.DB(systemConf.DBName), req, feed)
+    var b bytes.Buffer
+    if err == nil {
+        // HTML of items is left unescaped, as feed readers show it
+        encoder := json.NewEncoder(&b)
+        encoder.SetEscapeHTML(false)
+        encoder.SetIndent("", "  ")
+        err = encoder.Encode(doc)
+    }
+    if err != nil {
+        log.Println(err)
+        http.Error(c, "Server error", http.StatusInternalServerError)
+        return
+    }
+    c.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+    writeCached(c, req, b.String(), time.Time{})
+}
+
+// Registers the feed routes, for the whole blog, tags and categories
+func setFeedUrls(r *mux.Router) {
+    for _, prefix := range []string{"/", "/tag/{tagSlug:[\\w\\-]+}/", "/category/{categorySlug:[\\w\\-]+}/"} {
+        r.HandleFunc(prefix + "feed.atom", AtomFeedHandler)
+        r.HandleFunc(prefix + "feed.rss", RssFeedHandler)
+        r.HandleFunc(prefix + "feed.json", JsonFeedHandler)
+    }
+}
@@ -0,0 +1,675 @@
+package cms
+
+import (
+    "log"
+    "time"
+    "errors"
+    "strconv"
+    "context"
+    "net/url"
+    "net/http"
+    "io/ioutil"
+    "encoding/json"
+    "labix.org/v2/mgo/bson"
+    "github.com/graphql-go/graphql"
+)
+
+// Keys of the request flags in the context of GraphQL resolvers
+type graphqlContextKey string
+const superuserContextKey = graphqlContextKey("superuser")
+const postContextKey = graphqlContextKey("post")
+
+// Returns an error unless the request was made by a superuser, the same check
+// RequireSuperuser does for the REST handlers. Mutations must also come through
+// POST, so they can't be triggered by links.
+func graphqlRequireSuperuser(p graphql.ResolveParams) error {
+    if !graphqlIsSuperuser(p) {
+        return errors.New("Unauthorized")
+    } else if post, _ := p.Context.Value(postContextKey).(bool); !post {
+        return errors.New("Mutations must be sent with POST")
+    }
+    return nil
+}
+
+// Returns true if the request was made by a superuser
+func graphqlIsSuperuser(p graphql.ResolveParams) bool {
+    superuser, _ := p.Context.Value(superuserContextKey).(bool)
+    return superuser
+}
+
+// Resolves the "id" field of documents, whose ObjectIds are raw bytes
+func resolveObjectId(p graphql.ResolveParams) (interface{}, error) {
+    switch doc := p.Source.(type) {
+    case BlogPost:
+        return doc.Id.Hex(), nil
+    case Page:
+        return doc.Id.Hex(), nil
+    case Photo:
+        return doc.Id.Hex(), nil
+    }
+    return nil, nil
+}
+
+var graphqlPhotoType = graphql.NewObject(graphql.ObjectConfig{
+    Name: "Photo",
+    Fields: graphql.Fields{
+        "id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: resolveObjectId},
+        "filename": &graphql.Field{Type: graphql.String},
+        "url": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+            return "/static/photos/" + p.Source.(Photo).Filename, nil
+        }},
+        "mimeType": &graphql.Field{Type: graphql.String},
+        "published": &graphql.Field{Type: graphql.Boolean},
+        "pubDate": &graphql.Field{Type: graphql.DateTime},
+        "modified": &graphql.Field{Type: graphql.DateTime},
+        "author": &graphql.Field{Type: graphql.String},
+        "tags": &graphql.Field{Type: graphql.NewList(graphql.String)},
+        "tagSlugs": &graphql.Field{Type: graphql.NewList(graphql.String)},
+    },
+})
+
+// Photos sharing any tag with a post or page
+var graphqlRelatedPhotosField = &graphql.Field{
+    Type: graphql.NewList(graphqlPhotoType),
+    Description: "Published photos sharing any tag",
+    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+        var tags []string
+        switch doc := p.Source.(type) {
+        case BlogPost:
+            tags = doc.Tags
+        case Page:
+            tags = doc.Tags
+        }
+        return ListPhotosByTags(dbDefaultConn.DB(systemConf.DBName), tags)
+    },
+}
+
+var graphqlBlogPostType = graphql.NewObject(graphql.ObjectConfig{
+    Name: "BlogPost",
+    Fields: graphql.Fields{
+        "id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: resolveObjectId},
+        "slug": &graphql.Field{Type: graphql.String},
+        "title": &graphql.Field{Type: graphql.String},
+        "content": &graphql.Field{Type: graphql.String},
+        "summary": &graphql.Field{Type: graphql.String},
+        "truncated": &graphql.Field{Type: graphql.Boolean},
+        "wordCount": &graphql.Field{Type: graphql.Int},
+        "readingTime": &graphql.Field{Type: graphql.Int},
+        "published": &graphql.Field{Type: graphql.Boolean},
+        "pubDate": &graphql.Field{Type: graphql.DateTime},
+        "modified": &graphql.Field{Type: graphql.DateTime},
+        "author": &graphql.Field{Type: graphql.String},
+        "tags": &graphql.Field{Type: graphql.NewList(graphql.String)},
+        "tagSlugs": &graphql.Field{Type: graphql.NewList(graphql.String)},
+        "oldSlugs": &graphql.Field{Type: graphql.NewList(graphql.String)},
+        "permalink": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+            if post, ok := p.Source.(BlogPost); ok {
+                return post.Url(), nil
+            }
+            return nil, nil
+        }},
+        "photos": graphqlRelatedPhotosField,
+    },
+})
+
+var graphqlPageType = graphql.NewObject(graphql.ObjectConfig{
+    Name: "Page",
+    Fields: graphql.Fields{
+        "id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: resolveObjectId},
+        "slug": &graphql.Field{Type: graphql.String},
+        "title": &graphql.Field{Type: graphql.String},
+        "content": &graphql.Field{Type: graphql.String},
+        "published": &graphql.Field{Type: graphql.Boolean},
+        "pubDate": &graphql.Field{Type: graphql.DateTime},
+        "modified": &graphql.Field{Type: graphql.DateTime},
+        "author": &graphql.Field{Type: graphql.String},
+        "tags": &graphql.Field{Type: graphql.NewList(graphql.String)},
+        "tagSlugs": &graphql.Field{Type: graphql.NewList(graphql.String)},
+        "oldSlugs": &graphql.Field{Type: graphql.NewList(graphql.String)},
+        "photos": graphqlRelatedPhotosField,
+    },
+})
+
+var graphqlTagType = graphql.NewObject(graphql.ObjectConfig{
+    Name: "Tag",
+    Fields: graphql.Fields{
+        "slug": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+        "name": &graphql.Field{Type: graphql.String},
+        "posts": &graphql.Field{Type: graphql.Int},
+        "pages": &graphql.Field{Type: graphql.Int},
+        "photos": &graphql.Field{Type: graphql.Int},
+        "total": &graphql.Field{Type: graphql.Int},
+    },
+})
+
+var graphqlMenuItemType = graphql.NewObject(graphql.ObjectConfig{
+    Name: "MenuItem",
+    Fields: graphql.Fields{
+        "id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+        "url": &graphql.Field{Type: graphql.String},
+        "label": &graphql.Field{Type: graphql.String},
+        "position": &graphql.Field{Type: graphql.Int},
+    },
+})
+
+// A page of results, as returned by the REST list endpoints
+func graphqlListType(name string, itemType *graphql.Object) *graphql.Object {
+    return graphql.NewObject(graphql.ObjectConfig{
+        Name: name,
+        Fields: graphql.Fields{
+            "items": &graphql.Field{Type: graphql.NewList(itemType)},
+            "total": &graphql.Field{Type: graphql.Int},
+            "page": &graphql.Field{Type: graphql.Int},
+            "limit": &graphql.Field{Type: graphql.Int},
+        },
+    })
+}
+
+type graphqlList struct {
+    Items interface{}
+    Total int
+    Page int
+    Limit int
+}
+
+// Arguments of list queries, the same as the REST query string
+var graphqlListArgs = graphql.FieldConfigArgument{
+    "page": &graphql.ArgumentConfig{Type: graphql.Int},
+    "limit": &graphql.ArgumentConfig{Type: graphql.Int},
+    "sort": &graphql.ArgumentConfig{Type: graphql.String},
+    "tag": &graphql.ArgumentConfig{Type: graphql.String},
+    "author": &graphql.ArgumentConfig{Type: graphql.String},
+    "since": &graphql.ArgumentConfig{Type: graphql.String},
+    "until": &graphql.ArgumentConfig{Type: graphql.String},
+    "published": &graphql.ArgumentConfig{Type: graphql.String, Description: "true, false or all, for superusers only"},
+}
+
+// Converts list arguments to ListOptions
+func graphqlListOptions(p graphql.ResolveParams, sortFields map[string]string, defaultSort string) (ListOptions, error) {
+    values := url.Values{}
+    for name, value := range p.Args {
+        switch v := value.(type) {
+        case string:
+            values.Set(name, v)
+        case int:
+            values.Set(name, strconv.Itoa(v))
+        }
+    }
+    return listOptionsFromValues(values, graphqlIsSuperuser(p), sortFields, defaultSort, DEFAULT_PAGE_LIMIT)
+}
+
+var graphqlContentInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+    Name: "ContentInput",
+    Fields: graphql.InputObjectConfigFieldMap{
+        "title": &graphql.InputObjectFieldConfig{Type: graphql.String},
+        "content": &graphql.InputObjectFieldConfig{Type: graphql.String},
+        "slug": &graphql.InputObjectFieldConfig{Type: graphql.String},
+        "tags": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.String)},
+        "pubDate": &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+        "published": &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+    },
+})
+
+var graphqlMenuItemInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+    Name: "MenuItemInput",
+    Fields: graphql.InputObjectConfigFieldMap{
+        "id": &graphql.InputObjectFieldConfig{Type: graphql.String},
+        "url": &graphql.InputObjectFieldConfig{Type: graphql.String},
+        "label": &graphql.InputObjectFieldConfig{Type: graphql.String},
+        "position": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+    },
+})
+
+// Converts a ContentInput argument to the payload the REST handlers use
+func graphqlContentPayload(input map[string]interface{}) ContentPayload {
+    var payload ContentPayload
+    if v, ok := input["title"].(string); ok {
+        payload.Title = &v
+    }
+    if v, ok := input["content"].(string); ok {
+        payload.Content = &v
+    }
+    if v, ok := input["slug"].(string); ok {
+        payload.Slug = &v
+    }
+    if v, ok := input["tags"].([]interface{}); ok {
+        tags := make([]string,0)
+        for _, tag := range v {
+            if s, ok := tag.(string); ok {
+                tags = append(tags, s)
+            }
+        }
+        tags = cleanTags(tags)
+        payload.Tags = &tags
+    }
+    if v, ok := input["pubDate"].(time.Time); ok {
+        payload.PubDate = &v
+    } else if v, ok := input["pubDate"].(*time.Time); ok && v != nil {
+        payload.PubDate = v
+    }
+    if v, ok := input["published"].(bool); ok {
+        payload.Published = &v
+    }
+    return payload
+}
+
+// Converts a MenuItemInput argument to the payload the REST handlers use
+func graphqlMenuItemPayload(input map[string]interface{}) MenuItemPayload {
+    var payload MenuItemPayload
+    if v, ok := input["id"].(string); ok {
+        payload.Id = &v
+    }
+    if v, ok := input["url"].(string); ok {
+        payload.Url = &v
+    }
+    if v, ok := input["label"].(string); ok {
+        payload.Label = &v
+    }
+    if v, ok := input["position"].(int); ok {
+        payload.Position = &v
+    }
+    return payload
+}
+
+var graphqlQueryType = graphql.NewObject(graphql.ObjectConfig{
+    Name: "Query",
+    Fields: graphql.Fields{
+        "posts": &graphql.Field{
+            Type: graphqlListType("BlogPostList", graphqlBlogPostType),
+            Args: graphqlListArgs,
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                opts, err := graphqlListOptions(p, blogPostSortFields, "-pubdate")
+                if err != nil {
+                    return nil, err
+                }
+                posts, total, err := FindBlogPosts(dbDefaultConn.DB(systemConf.DBName), opts)
+                return graphqlList{Items:posts, Total:total, Page:opts.Page, Limit:opts.Limit}, err
+            },
+        },
+        "post": &graphql.Field{
+            Type: graphqlBlogPostType,
+            Args: graphql.FieldConfigArgument{
+                "id": &graphql.ArgumentConfig{Type: graphql.ID},
+                "slug": &graphql.ArgumentConfig{Type: graphql.String},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                db := dbDefaultConn.DB(systemConf.DBName)
+                var post BlogPost
+                var err error
+                if id, ok := p.Args["id"].(string); ok && bson.IsObjectIdHex(id) {
+                    post, err = GetBlogPost(db, id)
+                } else if slug, ok := p.Args["slug"].(string); ok {
+                    post, err = GetBlogPostBySlug(db, slug)
+                    if err != nil {
+                        post, err = GetBlogPostByOldSlug(db, slug)
+                    }
+                } else {
+                    return nil, errors.New("A valid id or slug is required")
+                }
+
+                // Drafts are only seen by superusers
+                if err != nil || (!post.Published && !graphqlIsSuperuser(p)) {
+                    return nil, errors.New("Not found")
+                }
+                return post, nil
+            },
+        },
+        "pages": &graphql.Field{
+            Type: graphqlListType("PageList", graphqlPageType),
+            Args: graphqlListArgs,
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                opts, err := graphqlListOptions(p, pageSortFields, "title")
+                if err != nil {
+                    return nil, err
+                }
+                pages, total, err := FindPages(dbDefaultConn.DB(systemConf.DBName), opts)
+                return graphqlList{Items:pages, Total:total, Page:opts.Page, Limit:opts.Limit}, err
+            },
+        },
+        "page": &graphql.Field{
+            Type: graphqlPageType,
+            Args: graphql.FieldConfigArgument{
+                "id": &graphql.ArgumentConfig{Type: graphql.ID},
+                "slug": &graphql.ArgumentConfig{Type: graphql.String},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                db := dbDefaultConn.DB(systemConf.DBName)
+                var page Page
+                var err error
+                if id, ok := p.Args["id"].(string); ok && bson.IsObjectIdHex(id) {
+                    page, err = GetPage(db, id)
+                } else if slug, ok := p.Args["slug"].(string); ok {
+                    page, err = GetPageBySlug(db, slug)
+                    if err != nil {
+                        page, err = GetPageByOldSlug(db, slug)
+                    }
+                } else {
+                    return nil, errors.New("A valid id or slug is required")
+                }
+
+                if err != nil || (!page.Published && !graphqlIsSuperuser(p)) {
+                    return nil, errors.New("Not found")
+                }
+                return page, nil
+            },
+        },
+        "photos": &graphql.Field{
+            Type: graphqlListType("PhotoList", graphqlPhotoType),
+            Args: graphqlListArgs,
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                opts, err := graphqlListOptions(p, photoSortFields, "-pubdate")
+                if err != nil {
+                    return nil, err
+                }
+                photos, total, err := FindPhotos(dbDefaultConn.DB(systemConf.DBName), opts)
+                return graphqlList{Items:photos, Total:total, Page:opts.Page, Limit:opts.Limit}, err
+            },
+        },
+        "photo": &graphql.Field{
+            Type: graphqlPhotoType,
+            Args: graphql.FieldConfigArgument{
+                "id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                id, _ := p.Args["id"].(string)
+                if !bson.IsObjectIdHex(id) {
+                    return nil, errors.New("Invalid id")
+                }
+                photo, err := GetPhoto(dbDefaultConn.DB(systemConf.DBName), id)
+                if err != nil || (!photo.Published && !graphqlIsSuperuser(p)) {
+                    return nil, errors.New("Not found")
+                }
+                return photo, nil
+            },
+        },
+        "tags": &graphql.Field{
+            Type: graphql.NewList(graphqlTagType),
+            Description: "Tags of published posts, pages and photos",
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                return ListTags(dbDefaultConn.DB(systemConf.DBName), false)
+            },
+        },
+        "menuItems": &graphql.Field{
+            Type: graphql.NewList(graphqlMenuItemType),
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                return ListMenuItems(dbDefaultConn.DB(systemConf.DBName))
+            },
+        },
+    },
+})
+
+// Arguments of mutations changing a document
+func graphqlUpdateArgs(inputType graphql.Input) graphql.FieldConfigArgument {
+    return graphql.FieldConfigArgument{
+        "id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+        "input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(inputType)},
+    }
+}
+
+var graphqlIdArgs = graphql.FieldConfigArgument{
+    "id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+}
+
+var graphqlMutationType = graphql.NewObject(graphql.ObjectConfig{
+    Name: "Mutation",
+    Fields: graphql.Fields{
+        "createPost": &graphql.Field{
+            Type: graphqlBlogPostType,
+            Args: graphql.FieldConfigArgument{
+                "input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphqlContentInputType)},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                payload := graphqlContentPayload(p.Args["input"].(map[string]interface{}))
+                if err := payload.validate(false); err != nil {
+                    return nil, err
+                }
+                post := BlogPost{Published:true, Author:DEFAULT_AUTHOR, Tags:make([]string,0)}
+                payload.applyToBlogPost(&post)
+                if post.Slug == "" {
+                    post.Slug = Slugify(post.Title)
+                }
+                err := InsertNewBlogPost(dbDefaultConn.DB(systemConf.DBName), &post)
+                return post, err
+            },
+        },
+        "updatePost": &graphql.Field{
+            Type: graphqlBlogPostType,
+            Description: "Changes the fields sent in the input",
+            Args: graphqlUpdateArgs(graphqlContentInputType),
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                db := dbDefaultConn.DB(systemConf.DBName)
+                id, _ := p.Args["id"].(string)
+                if !bson.IsObjectIdHex(id) {
+                    return nil, errors.New("Invalid id")
+                }
+                post, err := GetBlogPost(db, id)
+                if err != nil {
+                    return nil, err
+                }
+                graphqlContentPayload(p.Args["input"].(map[string]interface{})).applyToBlogPost(&post)
+                err = UpdateBlogPost(db, &post)
+                return post, err
+            },
+        },
+        "deletePost": &graphql.Field{
+            Type: graphql.Boolean,
+            Args: graphqlIdArgs,
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                id, _ := p.Args["id"].(string)
+                if !bson.IsObjectIdHex(id) {
+                    return nil, errors.New("Invalid id")
+                }
+                err := DeleteBlogPost(dbDefaultConn.DB(systemConf.DBName), id)
+                return err == nil, err
+            },
+        },
+        "createPage": &graphql.Field{
+            Type: graphqlPageType,
+            Args: graphql.FieldConfigArgument{
+                "input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphqlContentInputType)},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                payload := graphqlContentPayload(p.Args["input"].(map[string]interface{}))
+                if err := payload.validate(true); err != nil {
+                    return nil, err
+                }
+                page := Page{Published:true, Author:DEFAULT_AUTHOR, Tags:make([]string,0)}
+                payload.applyToPage(&page)
+                err := InsertNewPage(dbDefaultConn.DB(systemConf.DBName), &page)
+                return page, err
+            },
+        },
+        "updatePage": &graphql.Field{
+            Type: graphqlPageType,
+            Description: "Changes the fields sent in the input",
+            Args: graphqlUpdateArgs(graphqlContentInputType),
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                db := dbDefaultConn.DB(systemConf.DBName)
+                id, _ := p.Args["id"].(string)
+                if !bson.IsObjectIdHex(id) {
+                    return nil, errors.New("Invalid id")
+                }
+                page, err := GetPage(db, id)
+                if err != nil {
+                    return nil, err
+                }
+                graphqlContentPayload(p.Args["input"].(map[string]interface{})).applyToPage(&page)
+                err = UpdatePage(db, &page)
+                return page, err
+            },
+        },
+        "deletePage": &graphql.Field{
+            Type: graphql.Boolean,
+            Args: graphqlIdArgs,
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                id, _ := p.Args["id"].(string)
+                if !bson.IsObjectIdHex(id) {
+                    return nil, errors.New("Invalid id")
+                }
+                err := DeletePage(dbDefaultConn.DB(systemConf.DBName), id)
+                return err == nil, err
+            },
+        },
+        "updatePhoto": &graphql.Field{
+            Type: graphqlPhotoType,
+            Description: "Changes tags or publishing of a photo",
+            Args: graphqlUpdateArgs(graphqlContentInputType),
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                db := dbDefaultConn.DB(systemConf.DBName)
+                id, _ := p.Args["id"].(string)
+                if !bson.IsObjectIdHex(id) {
+                    return nil, errors.New("Invalid id")
+                }
+                photo, err := GetPhoto(db, id)
+                if err != nil {
+                    return nil, err
+                }
+                graphqlContentPayload(p.Args["input"].(map[string]interface{})).applyToPhoto(&photo)
+                err = UpdatePhoto(db, &photo)
+                return photo, err
+            },
+        },
+        "createMenuItem": &graphql.Field{
+            Type: graphqlMenuItemType,
+            Args: graphql.FieldConfigArgument{
+                "input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphqlMenuItemInputType)},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                payload := graphqlMenuItemPayload(p.Args["input"].(map[string]interface{}))
+                if err := payload.validate(); err != nil {
+                    return nil, err
+                }
+                item := MenuItem{Id:"menu-" + Slugify(*payload.Label)}
+                if payload.Id != nil && *payload.Id != "" {
+                    item.Id = *payload.Id
+                }
+                payload.applyToMenuItem(&item)
+                err := InsertNewMenuItem(dbDefaultConn.DB(systemConf.DBName), &item)
+                return item, err
+            },
+        },
+        "updateMenuItem": &graphql.Field{
+            Type: graphqlMenuItemType,
+            Description: "Changes the fields sent in the input",
+            Args: graphqlUpdateArgs(graphqlMenuItemInputType),
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                db := dbDefaultConn.DB(systemConf.DBName)
+                item, err := GetMenuItem(db, p.Args["id"].(string))
+                if err != nil {
+                    return nil, err
+                }
+                graphqlMenuItemPayload(p.Args["input"].(map[string]interface{})).applyToMenuItem(&item)
+                err = UpdateMenuItem(db, &item)
+                return item, err
+            },
+        },
+        "deleteMenuItem": &graphql.Field{
+            Type: graphql.Boolean,
+            Args: graphqlIdArgs,
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                if err := graphqlRequireSuperuser(p); err != nil {
+                    return nil, err
+                }
+                err := DeleteMenuItem(dbDefaultConn.DB(systemConf.DBName), p.Args["id"].(string))
+                return err == nil, err
+            },
+        },
+    },
+})
+
+var graphqlSchema, graphqlSchemaErr = graphql.NewSchema(graphql.SchemaConfig{
+    Query: graphqlQueryType,
+    Mutation: graphqlMutationType,
+})
+
+// Body of GraphQL requests
+type graphqlRequest struct {
+    Query string `json:"query"`
+    OperationName string `json:"operationName"`
+    Variables map[string]interface{} `json:"variables"`
+}
+
+// GraphQL endpoint. Accepts GET with a "query" parameter, or POST with a JSON
+// body and Content-Type; mutations are only accepted through POST.
+func GraphqlHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    var request graphqlRequest
+
+    if graphqlSchemaErr != nil {
+        writeJSONError(c, http.StatusInternalServerError, graphqlSchemaErr.Error())
+        return
+    }
+
+    switch req.Method {
+    case "GET":
+        values := req.URL.Query()
+        request.Query = values.Get("query")
+        request.OperationName = values.Get("operationName")
+        if variables := values.Get("variables"); variables != "" {
+            if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+                writeJSONError(c, http.StatusBadRequest, "Invalid variables: " + err.Error())
+                return
+            }
+        }
+
+    case "POST":
+        // Forms can't send JSON across sites, so a session cookie alone can't
+        // authorize mutations sent from other sites
+        if !isJSONRequest(req) {
+            writeJSONError(c, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+            return
+        }
+        body, err := ioutil.ReadAll(req.Body)
+        if err == nil {
+            err = json.Unmarshal(body, &request)
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, "Invalid JSON: " + err.Error())
+            return
+        }
+
+    default:
+        methodNotAllowed(c, "GET", "POST")
+        return
+    }
+
+    ctx := context.WithValue(req.Context(), superuserContextKey, IsSuperuser(c, req))
+    ctx = context.WithValue(ctx, postContextKey, req.Method == "POST")
+
+    result := graphql.Do(graphql.Params{
+        Schema: graphqlSchema,
+        RequestString: request.Query,
+        VariableValues: request.Variables,
+        OperationName: request.OperationName,
+        Context: ctx,
+    })
+
+    writeJSON(c, http.StatusOK, result)
+}
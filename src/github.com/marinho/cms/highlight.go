@@ -0,0 +1,173 @@
+package cms
+
+import (
+    "log"
+    "sync"
+    "time"
+    "bytes"
+    "strconv"
+    "strings"
+    "net/http"
+    "github.com/alecthomas/chroma"
+    chromahtml "github.com/alecthomas/chroma/formatters/html"
+    "github.com/alecthomas/chroma/lexers"
+    "github.com/alecthomas/chroma/styles"
+    "github.com/russross/blackfriday"
+)
+
+// Style of code blocks when the configuration sets none
+const DEFAULT_HIGHLIGHT_STYLE = "github"
+
+// Address of the stylesheet of code blocks, generated from the style
+const HIGHLIGHT_CSS_URL = "/static/css/highlight.css"
+
+// Markdown options, the same as blackfriday.MarkdownCommon
+const markdownHtmlFlags = blackfriday.HTML_USE_XHTML | blackfriday.HTML_USE_SMARTYPANTS |
+    blackfriday.HTML_SMARTYPANTS_FRACTIONS | blackfriday.HTML_SMARTYPANTS_DASHES |
+    blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+const markdownExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS | blackfriday.EXTENSION_TABLES |
+    blackfriday.EXTENSION_FENCED_CODE | blackfriday.EXTENSION_AUTOLINK |
+    blackfriday.EXTENSION_STRIKETHROUGH | blackfriday.EXTENSION_SPACE_HEADERS |
+    blackfriday.EXTENSION_HEADER_IDS | blackfriday.EXTENSION_BACKSLASH_LINE_BREAK |
+    blackfriday.EXTENSION_DEFINITION_LISTS
+
+// Stylesheet of code blocks, generated once
+var highlightCss = struct {
+    sync.Once
+    css string
+}{}
+
+// How a fenced code block is highlighted, from the words after its opening
+// fence, as in ```go linenos hl="2,4-6" start="10"
+type codeBlockOptions struct {
+    Language string // Guessed from the code when empty or unknown
+    LineNumbers bool
+    Start int // Number of the first line
+    Highlight [][2]int // Ranges of line numbers, inclusive
+}
+
+// Reads the options of a code block. Words it doesn't know are ignored, so
+// blocks written for other renderers still show.
+func parseCodeBlockOptions(info string) codeBlockOptions {
+    opts := codeBlockOptions{Start:1}
+    for i, word := range strings.Fields(info) {
+        key, value := word, ""
+        if j := strings.Index(word, "="); j >= 0 {
+            key, value = word[:j], strings.Trim(word[j+1:], "\"'")
+        } else if i == 0 {
+            opts.Language = word
+            continue
+        }
+
+        switch key {
+        case "linenos":
+            opts.LineNumbers = value == "" || value == "true"
+        case "start":
+            if start, err := strconv.Atoi(value); err == nil {
+                opts.Start = start
+            }
+        case "hl", "hl_lines":
+            for _, part := range strings.Split(value, ",") {
+                bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+                first, err := strconv.Atoi(bounds[0])
+                last := first
+                if err == nil && len(bounds) == 2 {
+                    last, err = strconv.Atoi(bounds[1])
+                }
+                if err == nil && first <= last {
+                    opts.Highlight = append(opts.Highlight, [2]int{first, last})
+                }
+            }
+        }
+    }
+    return opts
+}
+
+// Returns the lexer of the language, or of the one the code looks like
+func codeLexer(language string, code string) chroma.Lexer {
+    var lexer chroma.Lexer
+    if language != "" {
+        lexer = lexers.Get(language)
+    }
+    if lexer == nil {
+        lexer = lexers.Analyse(code)
+    }
+    if lexer == nil {
+        lexer = lexers.Fallback
+    }
+    return chroma.Coalesce(lexer)
+}
+
+// Returns the formatter of code blocks, with classes styled by the stylesheet
+func codeFormatter(opts codeBlockOptions) *chromahtml.Formatter {
+    return chromahtml.New(chromahtml.WithClasses(true), chromahtml.TabWidth(4),
+        chromahtml.WithLineNumbers(opts.LineNumbers), chromahtml.LineNumbersInTable(true),
+        chromahtml.BaseLineNumber(opts.Start), chromahtml.HighlightLines(opts.Highlight))
+}
+
+// Returns the code highlighted as HTML, by the options of its block
+func highlightCode(code string, info string) (string, error) {
+    opts := parseCodeBlockOptions(info)
+    iterator, err := codeLexer(opts.Language, code).Tokenise(nil, code)
+    if err != nil {
+        return "", err
+    }
+    var out bytes.Buffer
+    err = codeFormatter(opts).Format(&out, highlightStyle(), iterator)
+    return out.String(), err
+}
+
+// Returns the style of the configuration, or the default one
+func highlightStyle() *chroma.Style {
+    name := systemConf.HighlightStyle
+    if name == "" {
+        name = DEFAULT_HIGHLIGHT_STYLE
+    }
+    return styles.Get(name)
+}
+
+// Markdown renderer highlighting fenced code blocks
+type highlightRenderer struct {
+    *blackfriday.Html
+}
+
+func (r highlightRenderer) BlockCode(out *bytes.Buffer, text []byte, info string) {
+    code, err := highlightCode(string(text), info)
+    if err != nil {
+        // Shown as plain code instead
+        log.Println("Highlighting error:", err)
+        r.Html.BlockCode(out, text, info)
+        return
+    }
+    if out.Len() > 0 {
+        out.WriteByte('\n')
+    }
+    out.WriteString(code)
+}
+
+// Returns a renderer of Markdown to HTML that highlights code
+func newMarkdownRenderer() blackfriday.Renderer {
+    return highlightRenderer{blackfriday.HtmlRenderer(markdownHtmlFlags, "", "").(*blackfriday.Html)}
+}
+
+/* HANDLERS */
+
+// Stylesheet of highlighted code, in the style of the configuration
+func HighlightCssHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        http.Error(c, "Invalid method.", http.StatusMethodNotAllowed)
+        return
+    }
+
+    highlightCss.Do(func() {
+        var css bytes.Buffer
+        // Line numbers on, so their rules are included
+        if err := codeFormatter(codeBlockOptions{LineNumbers:true, Start:1}).WriteCSS(&css, highlightStyle()); err != nil {
+            log.Println(err)
+        }
+        highlightCss.css = css.String()
+    })
+    c.Header().Set("Content-Type", "text/css; charset=utf-8")
+    writeCached(c, req, highlightCss.css, time.Time{})
+}
@@ -0,0 +1,209 @@
+package cms
+
+import (
+    "fmt"
+    "time"
+    "errors"
+    "strings"
+    "strconv"
+    "net/url"
+    "net/http"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+const DEFAULT_PAGE_LIMIT = 20
+const MAX_PAGE_LIMIT = 100
+
+// Pagination, filtering and sorting options for list queries
+type ListOptions struct {
+    Page int // Starts at 1
+    Limit int // Zero means no limit
+    Sort []string // Database field names, prefixed with "-" for descending order
+    Tag string // Name or slug
+    Category string // Slug, posts in its subcategories included
+    Author string
+    Since time.Time
+    Until time.Time
+    Published *bool // Nil lists published and unpublished items
+    categoryIds []bson.ObjectId // Category and subcategories, resolved by FindBlogPosts
+}
+
+// Database fields each list can be sorted by, keyed by lower case API name
+var blogPostSortFields = map[string]string{"pubdate":"pubdate", "title":"title", "slug":"slug", "author":"author"}
+var pageSortFields = map[string]string{"pubdate":"pubdate", "title":"title", "slug":"slug", "author":"author"}
+var photoSortFields = map[string]string{"pubdate":"pubdate", "filename":"filename", "author":"author"}
+
+// Returns the MongoDB filter for the options
+func (opts ListOptions) filter() bson.M {
+    filter := bson.M{}
+    if opts.Published != nil {
+        filter["published"] = *opts.Published
+    }
+    if opts.Tag != "" {
+        filter["tagslugs"] = Slugify(opts.Tag)
+    }
+    if opts.Category != "" {
+        filter["categories"] = bson.M{"$in":opts.categoryIds}
+    }
+    if opts.Author != "" {
+        filter["author"] = opts.Author
+    }
+    if !opts.Since.IsZero() || !opts.Until.IsZero() {
+        dateRange := bson.M{}
+        if !opts.Since.IsZero() {
+            dateRange["$gte"] = opts.Since
+        }
+        if !opts.Until.IsZero() {
+            dateRange["$lte"] = opts.Until
+        }
+        filter["pubdate"] = dateRange
+    }
+    return filter
+}
+
+// Runs the query for the options, loading the current page into result. Returns
+// the total of items matching the filter, regardless of pagination.
+func findPaginated(coll *mgo.Collection, opts ListOptions, result interface{}) (int, error) {
+    query := coll.Find(opts.filter())
+
+    total, err := query.Count()
+    if err != nil {
+        return 0, err
+    }
+
+    // Ties are sorted by id, so pages don't repeat or miss items
+    sort := append([]string{}, opts.Sort...)
+    if !containsString(sort, "_id") && !containsString(sort, "-_id") {
+        sort = append(sort, "_id")
+    }
+    query = query.Sort(sort...)
+    if opts.Limit > 0 {
+        query = query.Skip((opts.Page - 1) * opts.Limit).Limit(opts.Limit)
+    }
+
+    err = query.All(result)
+    return total, err
+}
+
+// Parses a date in RFC 3339 or YYYY-MM-DD formats
+func parseListDate(value string) (time.Time, error) {
+    if date, err := time.Parse(time.RFC3339, value); err == nil {
+        return date, nil
+    }
+    return time.Parse("2006-01-02", value)
+}
+
+// Reads list options from the query string: page, limit, sort, tag, category,
+// author, since, until and published. Only superusers may list unpublished items.
+func parseListOptions(c http.ResponseWriter, req *http.Request, sortFields map[string]string, defaultSort string, defaultLimit int) (ListOptions, error) {
+    return listOptionsFromValues(req.URL.Query(), IsSuperuser(c, req), sortFields, defaultSort, defaultLimit)
+}
+
+// Reads list options from a set of values, as parseListOptions describes
+func listOptionsFromValues(values url.Values, superuser bool, sortFields map[string]string, defaultSort string, defaultLimit int) (ListOptions, error) {
+    published := true
+    opts := ListOptions{Page:1, Limit:defaultLimit, Published:&published}
+
+    if value := values.Get("page"); value != "" {
+        page, err := strconv.Atoi(value)
+        if err != nil || page < 1 {
+            return opts, errors.New("page must be a positive number")
+        }
+        opts.Page = page
+    }
+
+    if value := values.Get("limit"); value != "" {
+        limit, err := strconv.Atoi(value)
+        if err != nil || limit < 1 {
+            return opts, errors.New("limit must be a positive number")
+        }
+        opts.Limit = limit
+    }
+    if opts.Limit > MAX_PAGE_LIMIT {
+        opts.Limit = MAX_PAGE_LIMIT
+    }
+
+    sortValue := values.Get("sort")
+    if sortValue == "" {
+        sortValue = defaultSort
+    }
+    for _, field := range strings.Split(sortValue, ",") {
+        field = strings.ToLower(strings.TrimSpace(field))
+        prefix := ""
+        if strings.HasPrefix(field, "-") {
+            prefix = "-"
+            field = field[1:]
+        }
+        dbField, ok := sortFields[field]
+        if !ok {
+            return opts, fmt.Errorf("Can't sort by \"%v\"", field)
+        }
+        opts.Sort = append(opts.Sort, prefix + dbField)
+    }
+
+    opts.Tag = values.Get("tag")
+    opts.Category = values.Get("category")
+    opts.Author = values.Get("author")
+
+    if value := values.Get("since"); value != "" {
+        since, err := parseListDate(value)
+        if err != nil {
+            return opts, errors.New("since must be a date")
+        }
+        opts.Since = since
+    }
+    if value := values.Get("until"); value != "" {
+        until, err := parseListDate(value)
+        if err != nil {
+            return opts, errors.New("until must be a date")
+        }
+        opts.Until = until
+    }
+
+    if value := values.Get("published"); value != "" && superuser {
+        if value == "all" {
+            opts.Published = nil
+        } else {
+            published, err := strconv.ParseBool(value)
+            if err != nil {
+                return opts, errors.New("published must be true, false or all")
+            }
+            opts.Published = &published
+        }
+    }
+
+    return opts, nil
+}
+
+// Returns the request URL pointing to another page
+func pageUrl(req *http.Request, page int) string {
+    values := req.URL.Query()
+    values.Set("page", strconv.Itoa(page))
+    u := url.URL{Path:req.URL.Path, RawQuery:values.Encode()}
+    return u.String()
+}
+
+// Sets the X-Total-Count and Link headers for a paginated list
+func setPaginationHeaders(c http.ResponseWriter, req *http.Request, opts ListOptions, total int) {
+    c.Header().Set("X-Total-Count", strconv.Itoa(total))
+    if opts.Limit <= 0 {
+        return
+    }
+
+    lastPage := (total + opts.Limit - 1) / opts.Limit
+    if lastPage < 1 {
+        lastPage = 1
+    }
+
+    links := make([]string,0)
+    links = append(links, fmt.Sprintf("<%v>; rel=\"first\"", pageUrl(req, 1)))
+    if opts.Page > 1 {
+        links = append(links, fmt.Sprintf("<%v>; rel=\"prev\"", pageUrl(req, opts.Page - 1)))
+    }
+    if opts.Page < lastPage {
+        links = append(links, fmt.Sprintf("<%v>; rel=\"next\"", pageUrl(req, opts.Page + 1)))
+    }
+    links = append(links, fmt.Sprintf("<%v>; rel=\"last\"", pageUrl(req, lastPage)))
+    c.Header().Set("Link", strings.Join(links, ", "))
+}
@@ -0,0 +1,381 @@
+package cms
+
+import (
+    "log"
+    "time"
+    "strconv"
+    "regexp"
+    "reflect"
+    "strings"
+    "net/http"
+)
+
+// Describes one operation of the API. The OpenAPI document is generated from
+// this list, and the tests compare it with the routes of the router.
+type apiOperation struct {
+    Method string
+    Path string // Route template, as registered in the router
+    Tag string
+    Summary string
+    Superuser bool
+    Query []string // Query string parameters
+    Body string // Name of the request schema
+    Form bool // Body is multipart form data
+    Response string // Name of the response schema
+    Status int // Success status code
+}
+
+var listQuery = []string{"page", "limit", "sort", "tag", "author", "since", "until", "published"}
+var postListQuery = []string{"page", "limit", "sort", "tag", "category", "author", "since", "until", "published", "content"}
+
+var apiOperations = []apiOperation{
+    // General
+    {Method:"GET", Path:"/api/openapi.json", Tag:"General", Summary:"This document"},
+    {Method:"GET", Path:"/api/graphql", Tag:"General", Summary:"GraphQL queries, sent in the \"query\" parameter", Query:[]string{"query", "operationName", "variables"}},
+    {Method:"POST", Path:"/api/graphql", Tag:"General", Summary:"GraphQL queries and mutations", Body:"GraphqlRequest"},
+    {Method:"GET", Path:"/api/is-superuser/", Tag:"General", Summary:"Returns \"yes\" if the session is a superuser's"},
+    {Method:"POST", Path:"/login/", Tag:"General", Summary:"Starts a superuser session", Body:"LoginPayload", Response:"Result"},
+    {Method:"GET", Path:"/logout/", Tag:"General", Summary:"Ends the session and redirects to the admin", Status:http.StatusFound},
+    {Method:"GET", Path:"/api/menu/item/", Tag:"Menu items", Summary:"Public menu items", Response:"MenuItemList"},
+    {Method:"GET", Path:"/api/admin/menu/", Tag:"Menu items", Summary:"Admin menu items", Superuser:true, Response:"MenuItemList"},
+
+    // Webhooks
+    {Method:"GET", Path:"/api/admin/webhooks/", Tag:"Webhooks", Summary:"Lists webhooks and the events they can subscribe to", Superuser:true, Response:"WebhookList"},
+    {Method:"POST", Path:"/api/admin/webhooks/", Tag:"Webhooks", Summary:"Creates a webhook", Superuser:true, Body:"WebhookPayload", Response:"Webhook", Status:http.StatusCreated},
+    {Method:"GET", Path:"/api/admin/webhooks/{hookId}/", Tag:"Webhooks", Summary:"Returns a webhook", Superuser:true, Response:"Webhook"},
+    {Method:"PUT", Path:"/api/admin/webhooks/{hookId}/", Tag:"Webhooks", Summary:"Replaces a webhook", Superuser:true, Body:"WebhookPayload", Response:"Webhook"},
+    {Method:"PATCH", Path:"/api/admin/webhooks/{hookId}/", Tag:"Webhooks", Summary:"Changes the fields sent of a webhook", Superuser:true, Body:"WebhookPayload", Response:"Webhook"},
+    {Method:"DELETE", Path:"/api/admin/webhooks/{hookId}/", Tag:"Webhooks", Summary:"Deletes a webhook and its delivery log", Superuser:true, Status:http.StatusNoContent},
+    {Method:"GET", Path:"/api/admin/webhooks/{hookId}/deliveries/", Tag:"Webhooks", Summary:"Delivery log of a webhook", Superuser:true, Query:[]string{"page", "limit", "sort"}, Response:"WebhookDeliveryList"},
+    {Method:"POST", Path:"/api/admin/webhooks/{hookId}/ping/", Tag:"Webhooks", Summary:"Queues a \"ping\" delivery to the webhook", Superuser:true, Response:"WebhookDelivery", Status:http.StatusAccepted},
+
+    // Tags
+    {Method:"GET", Path:"/api/tag/", Tag:"Tags", Summary:"Lists tags with the number of posts, pages and photos having them", Query:[]string{"published"}, Response:"TagList"},
+    {Method:"GET", Path:"/api/tag/{tagSlug}/", Tag:"Tags", Summary:"Returns a tag with its counts", Query:[]string{"published"}, Response:"TagResult"},
+    {Method:"POST", Path:"/api/admin/tags/{tagSlug}/rename/", Tag:"Tags", Summary:"Renames a tag in every post, page and photo", Superuser:true, Body:"TagChangePayload", Response:"TagChangeResult"},
+    {Method:"POST", Path:"/api/admin/tags/merge/", Tag:"Tags", Summary:"Merges several tags into one, in every post, page and photo", Superuser:true, Body:"TagChangePayload", Response:"TagChangeResult"},
+
+    // Archive
+    {Method:"GET", Path:"/api/blog/archive/", Tag:"Blog posts v1", Summary:"Years and months with published posts, and their number of posts", Response:"BlogArchive"},
+
+    // Search
+    {Method:"GET", Path:"/api/search/", Tag:"Search", Summary:"Searches posts and pages, with the matches in snippets marked", Query:[]string{"q", "type", "page", "limit", "sort", "tag", "category", "author", "since", "until", "published"}, Response:"SearchResults"},
+
+    // Preview
+    {Method:"POST", Path:"/api/admin/preview/", Tag:"General", Summary:"Renders content as published, with the errors of its shortcodes", Superuser:true, Body:"PreviewPayload", Response:"PreviewResult"},
+
+    // Categories
+    {Method:"GET", Path:"/api/category/", Tag:"Categories", Summary:"Categories nested as a tree, with their number of published posts", Response:"CategoryTree"},
+    {Method:"GET", Path:"/api/category/{categorySlug}/", Tag:"Categories", Summary:"Returns a category by its slug, with its parent categories", Response:"CategoryResult"},
+    {Method:"GET", Path:"/api/v2/categories/", Tag:"Categories", Summary:"Lists categories", Response:"CategoryList"},
+    {Method:"POST", Path:"/api/v2/categories/", Tag:"Categories", Summary:"Creates a category", Superuser:true, Body:"CategoryPayload", Response:"Category", Status:http.StatusCreated},
+    {Method:"GET", Path:"/api/v2/categories/{categoryId}/", Tag:"Categories", Summary:"Returns a category", Response:"Category"},
+    {Method:"PUT", Path:"/api/v2/categories/{categoryId}/", Tag:"Categories", Summary:"Replaces a category", Superuser:true, Body:"CategoryPayload", Response:"Category"},
+    {Method:"PATCH", Path:"/api/v2/categories/{categoryId}/", Tag:"Categories", Summary:"Changes the fields sent of a category", Superuser:true, Body:"CategoryPayload", Response:"Category"},
+    {Method:"DELETE", Path:"/api/v2/categories/{categoryId}/", Tag:"Categories", Summary:"Deletes a category, moving its subcategories to its parent", Superuser:true, Status:http.StatusNoContent},
+
+    // Blog posts, version 1
+    {Method:"GET", Path:"/api/blog/post/", Tag:"Blog posts v1", Summary:"Lists blog posts, without their content unless content=full", Query:postListQuery, Response:"BlogPostList"},
+    {Method:"POST", Path:"/api/blog/post/add/", Tag:"Blog posts v1", Summary:"Creates a blog post", Superuser:true, Body:"ContentPayload", Response:"Result"},
+    {Method:"GET", Path:"/api/blog/post/{postId}/", Tag:"Blog posts v1", Summary:"Returns a blog post", Response:"BlogPostResult"},
+    {Method:"POST", Path:"/api/blog/post/{postId}/", Tag:"Blog posts v1", Summary:"Updates a blog post", Superuser:true, Body:"ContentPayload", Response:"Result"},
+    {Method:"GET", Path:"/api/blog/post/by-slug/{postSlug}/", Tag:"Blog posts v1", Summary:"Returns a blog post by its slug, with the previous and next ones", Response:"BlogPostResult"},
+    {Method:"POST", Path:"/api/blog/post/by-slug/{postSlug}/", Tag:"Blog posts v1", Summary:"Updates a blog post", Superuser:true, Body:"ContentPayload", Response:"Result"},
+    {Method:"POST", Path:"/api/blog/post/{postId}/delete/", Tag:"Blog posts v1", Summary:"Deletes a blog post", Superuser:true, Response:"Result"},
+
+    // Pages, version 1
+    {Method:"GET", Path:"/api/page/", Tag:"Pages v1", Summary:"Lists pages", Query:listQuery, Response:"PageList"},
+    {Method:"POST", Path:"/api/page/add/", Tag:"Pages v1", Summary:"Creates a page", Superuser:true, Body:"ContentPayload", Response:"Result"},
+    {Method:"GET", Path:"/api/page/{pageId}/", Tag:"Pages v1", Summary:"Returns a page", Response:"PageResult"},
+    {Method:"POST", Path:"/api/page/{pageId}/", Tag:"Pages v1", Summary:"Updates a page", Superuser:true, Body:"ContentPayload", Response:"Result"},
+    {Method:"POST", Path:"/api/page/{pageId}/delete/", Tag:"Pages v1", Summary:"Deletes a page", Superuser:true, Response:"Result"},
+    {Method:"GET", Path:"/api/page/by-slug/{pageSlug}/", Tag:"Pages v1", Summary:"Returns a page by its slug", Response:"PageResult"},
+    {Method:"POST", Path:"/api/page/by-slug/{pageSlug}/", Tag:"Pages v1", Summary:"Updates a page", Superuser:true, Body:"ContentPayload", Response:"Result"},
+
+    // Photos, version 1
+    {Method:"GET", Path:"/api/photo/", Tag:"Photos v1", Summary:"Lists photos", Query:listQuery, Response:"PhotoList"},
+    {Method:"GET", Path:"/api/photo/published/", Tag:"Photos v1", Summary:"Lists published photos", Query:listQuery, Response:"PhotoList"},
+
+    // Version 2
+    {Method:"GET", Path:"/api/v2/posts/", Tag:"Blog posts", Summary:"Lists blog posts, without their content unless content=full", Query:postListQuery, Response:"BlogPostList"},
+    {Method:"POST", Path:"/api/v2/posts/", Tag:"Blog posts", Summary:"Creates a blog post", Superuser:true, Body:"ContentPayload", Response:"BlogPost", Status:http.StatusCreated},
+    {Method:"POST", Path:"/api/v2/posts/bulk/", Tag:"Blog posts", Summary:"Publishes, unpublishes, deletes, retags or changes the author of several blog posts", Superuser:true, Body:"BulkPayload", Response:"BulkResults"},
+    {Method:"GET", Path:"/api/v2/posts/{postId}/", Tag:"Blog posts", Summary:"Returns a blog post", Response:"BlogPost"},
+    {Method:"GET", Path:"/api/v2/posts/{postId}/related/", Tag:"Blog posts", Summary:"Published posts related to a post, by shared tags and similar text", Response:"PostLinkList"},
+    {Method:"PUT", Path:"/api/v2/posts/{postId}/", Tag:"Blog posts", Summary:"Replaces a blog post, omitted fields get the values of new posts", Superuser:true, Body:"ContentPayload", Response:"BlogPost"},
+    {Method:"PATCH", Path:"/api/v2/posts/{postId}/", Tag:"Blog posts", Summary:"Changes the fields sent of a blog post", Superuser:true, Body:"ContentPayload", Response:"BlogPost"},
+    {Method:"DELETE", Path:"/api/v2/posts/{postId}/", Tag:"Blog posts", Summary:"Deletes a blog post", Superuser:true, Status:http.StatusNoContent},
+
+    {Method:"GET", Path:"/api/v2/pages/", Tag:"Pages", Summary:"Lists pages", Query:listQuery, Response:"PageList"},
+    {Method:"POST", Path:"/api/v2/pages/", Tag:"Pages", Summary:"Creates a page", Superuser:true, Body:"ContentPayload", Response:"Page", Status:http.StatusCreated},
+    {Method:"POST", Path:"/api/v2/pages/bulk/", Tag:"Pages", Summary:"Publishes, unpublishes, deletes, retags or changes the author of several pages", Superuser:true, Body:"BulkPayload", Response:"BulkResults"},
+    {Method:"GET", Path:"/api/v2/pages/{pageId}/", Tag:"Pages", Summary:"Returns a page", Response:"Page"},
+    {Method:"PUT", Path:"/api/v2/pages/{pageId}/", Tag:"Pages", Summary:"Replaces a page, omitted fields get the values of new pages", Superuser:true, Body:"ContentPayload", Response:"Page"},
+    {Method:"PATCH", Path:"/api/v2/pages/{pageId}/", Tag:"Pages", Summary:"Changes the fields sent of a page", Superuser:true, Body:"ContentPayload", Response:"Page"},
+    {Method:"DELETE", Path:"/api/v2/pages/{pageId}/", Tag:"Pages", Summary:"Deletes a page", Superuser:true, Status:http.StatusNoContent},
+
+    {Method:"GET", Path:"/api/v2/photos/", Tag:"Photos", Summary:"Lists photos", Query:listQuery, Response:"PhotoList"},
+    {Method:"POST", Path:"/api/v2/photos/", Tag:"Photos", Summary:"Uploads photos sent as \"media\" files", Superuser:true, Form:true, Response:"PhotoList", Status:http.StatusCreated},
+    {Method:"GET", Path:"/api/v2/photos/{photoId}/", Tag:"Photos", Summary:"Returns a photo", Response:"Photo"},
+    {Method:"PUT", Path:"/api/v2/photos/{photoId}/", Tag:"Photos", Summary:"Replaces tags and publishing of a photo, omitted fields get the values of new photos", Superuser:true, Body:"ContentPayload", Response:"Photo"},
+    {Method:"PATCH", Path:"/api/v2/photos/{photoId}/", Tag:"Photos", Summary:"Changes tags or publishing of a photo", Superuser:true, Body:"ContentPayload", Response:"Photo"},
+    {Method:"DELETE", Path:"/api/v2/photos/{photoId}/", Tag:"Photos", Summary:"Deletes a photo and its file", Superuser:true, Status:http.StatusNoContent},
+
+    {Method:"GET", Path:"/api/v2/menu-items/", Tag:"Menu items", Summary:"Lists menu items", Response:"MenuItemList"},
+    {Method:"POST", Path:"/api/v2/menu-items/", Tag:"Menu items", Summary:"Creates a menu item", Superuser:true, Body:"MenuItemPayload", Response:"MenuItem", Status:http.StatusCreated},
+    {Method:"GET", Path:"/api/v2/menu-items/{itemId}/", Tag:"Menu items", Summary:"Returns a menu item", Response:"MenuItem"},
+    {Method:"PUT", Path:"/api/v2/menu-items/{itemId}/", Tag:"Menu items", Summary:"Replaces a menu item, an omitted Position is 0", Superuser:true, Body:"MenuItemPayload", Response:"MenuItem"},
+    {Method:"PATCH", Path:"/api/v2/menu-items/{itemId}/", Tag:"Menu items", Summary:"Changes the fields sent of a menu item", Superuser:true, Body:"MenuItemPayload", Response:"MenuItem"},
+    {Method:"DELETE", Path:"/api/v2/menu-items/{itemId}/", Tag:"Menu items", Summary:"Deletes a menu item", Superuser:true, Status:http.StatusNoContent},
+}
+
+// Types whose schemas are generated from their Go definitions
+var apiSchemaTypes = map[string]reflect.Type{
+    "BlogPost": reflect.TypeOf(BlogPost{}),
+    "Page": reflect.TypeOf(Page{}),
+    "Photo": reflect.TypeOf(Photo{}),
+    "MenuItem": reflect.TypeOf(MenuItem{}),
+    "PostLink": reflect.TypeOf(PostLink{}),
+    "Tag": reflect.TypeOf(Tag{}),
+    "Category": reflect.TypeOf(Category{}),
+    "ArchiveYear": reflect.TypeOf(ArchiveYear{}),
+    "SearchResult": reflect.TypeOf(SearchResult{}),
+    "CategoryPayload": reflect.TypeOf(CategoryPayload{}),
+    "TagChangePayload": reflect.TypeOf(TagChangePayload{}),
+    "TagChangeResult": reflect.TypeOf(TagChangeResult{}),
+    "PreviewPayload": reflect.TypeOf(PreviewPayload{}),
+    "ShortcodeError": reflect.TypeOf(ShortcodeError{}),
+    "ContentPayload": reflect.TypeOf(ContentPayload{}),
+    "MenuItemPayload": reflect.TypeOf(MenuItemPayload{}),
+    "Webhook": reflect.TypeOf(Webhook{}),
+    "WebhookDelivery": reflect.TypeOf(WebhookDelivery{}),
+    "WebhookPayload": reflect.TypeOf(WebhookPayload{}),
+    "BulkPayload": reflect.TypeOf(BulkPayload{}),
+    "BulkResult": reflect.TypeOf(BulkResult{}),
+    "LoginPayload": reflect.TypeOf(LoginPayload{}),
+    "GraphqlRequest": reflect.TypeOf(graphqlRequest{}),
+}
+
+// Schemas of the envelopes some handlers wrap their responses in
+func envelopeSchemas() map[string]interface{} {
+    ref := func(name string) map[string]interface{} {
+        return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+    }
+    list := func(key string, item string) map[string]interface{} {
+        return map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            key: map[string]interface{}{"type": "array", "items": ref(item)},
+            "total": map[string]interface{}{"type": "integer"},
+            "page": map[string]interface{}{"type": "integer"},
+            "limit": map[string]interface{}{"type": "integer"},
+        }}
+    }
+    result := func(key string, item string) map[string]interface{} {
+        return map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "result": map[string]interface{}{"type": "string"},
+            key: ref(item),
+        }}
+    }
+
+    return map[string]interface{}{
+        "Result": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "result": map[string]interface{}{"type": "string", "enum": []string{"ok", "error"}},
+            "message": map[string]interface{}{"type": "string"},
+        }},
+        "BlogPostList": list("posts", "BlogPost"),
+        "PageList": list("pages", "Page"),
+        "PhotoList": list("photos", "Photo"),
+        "MenuItemList": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "items": map[string]interface{}{"type": "array", "items": ref("MenuItem")},
+        }},
+        "WebhookList": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "webhooks": map[string]interface{}{"type": "array", "items": ref("Webhook")},
+            "events": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+        }},
+        "WebhookDeliveryList": list("deliveries", "WebhookDelivery"),
+        "BulkResults": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "results": map[string]interface{}{"type": "array", "items": ref("BulkResult")},
+            "succeeded": map[string]interface{}{"type": "integer"},
+            "failed": map[string]interface{}{"type": "integer"},
+        }},
+        "BlogPostResult": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "result": map[string]interface{}{"type": "string"},
+            "post": ref("BlogPost"),
+            "previous": ref("PostLink"),
+            "next": ref("PostLink"),
+            "categories": map[string]interface{}{"type": "array", "items": ref("Category")},
+            "related": map[string]interface{}{"type": "array", "items": ref("PostLink")},
+            "html": map[string]interface{}{"type": "string"},
+        }},
+        "PostLinkList": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "posts": map[string]interface{}{"type": "array", "items": ref("PostLink")},
+        }},
+        "PageResult": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "result": map[string]interface{}{"type": "string"},
+            "page": ref("Page"),
+            "html": map[string]interface{}{"type": "string"},
+        }},
+        "PreviewResult": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "html": map[string]interface{}{"type": "string"},
+            "errors": map[string]interface{}{"type": "array", "items": ref("ShortcodeError")},
+        }},
+        "TagList": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "tags": map[string]interface{}{"type": "array", "items": ref("Tag")},
+            "total": map[string]interface{}{"type": "integer"},
+        }},
+        "TagResult": result("tag", "Tag"),
+        "SearchResults": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "query": map[string]interface{}{"type": "string"},
+            "results": map[string]interface{}{"type": "array", "items": ref("SearchResult")},
+            "total": map[string]interface{}{"type": "integer"},
+            "page": map[string]interface{}{"type": "integer"},
+            "limit": map[string]interface{}{"type": "integer"},
+        }},
+        "BlogArchive": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "years": map[string]interface{}{"type": "array", "items": ref("ArchiveYear")},
+        }},
+        "CategoryList": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "categories": map[string]interface{}{"type": "array", "items": ref("Category")},
+        }},
+        // Nested, so it can't be generated from the type
+        "CategoryNode": map[string]interface{}{"allOf": []interface{}{ref("Category"), map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "Posts": map[string]interface{}{"type": "integer"},
+            "Children": map[string]interface{}{"type": "array", "items": ref("CategoryNode")},
+        }}}},
+        "CategoryTree": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "categories": map[string]interface{}{"type": "array", "items": ref("CategoryNode")},
+        }},
+        "CategoryResult": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+            "result": map[string]interface{}{"type": "string"},
+            "category": ref("Category"),
+            "path": map[string]interface{}{"type": "array", "items": ref("Category")},
+        }},
+    }
+}
+
+// Returns the JSON schema of a Go type
+func schemaForType(t reflect.Type) map[string]interface{} {
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+
+    switch t.Kind() {
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case reflect.Int, reflect.Int32, reflect.Int64:
+        return map[string]interface{}{"type": "integer"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    case reflect.Slice:
+        return map[string]interface{}{"type": "array", "items": schemaForType(t.Elem())}
+    case reflect.Struct:
+        if t.PkgPath() == "time" && t.Name() == "Time" {
+            return map[string]interface{}{"type": "string", "format": "date-time"}
+        }
+        properties := map[string]interface{}{}
+        for i := 0; i < t.NumField(); i++ {
+            field := t.Field(i)
+            name := field.Name
+            if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" || field.PkgPath != "" {
+                continue
+            } else if tag != "" {
+                name = tag
+            }
+            properties[name] = schemaForType(field.Type)
+        }
+        return map[string]interface{}{"type": "object", "properties": properties}
+    }
+    return map[string]interface{}{}
+}
+
+// Route variables, whose patterns may have quantifiers like {24}
+var routeVariablePattern = regexp.MustCompile(`\{(\w+)(:(?:[^{}]|\{[^{}]*\})*)?\}`)
+
+// Turns a route template like /post/{postId:\w+}/ into /post/{postId}/
+func openApiPath(template string) string {
+    return routeVariablePattern.ReplaceAllString(template, "{$1}")
+}
+
+// Builds the OpenAPI 3 document from apiOperations
+func openApiDocument() map[string]interface{} {
+    paths := map[string]interface{}{}
+    for _, op := range apiOperations {
+        operation := map[string]interface{}{"summary": op.Summary, "tags": []string{op.Tag}}
+
+        parameters := make([]interface{},0)
+        for _, match := range routeVariablePattern.FindAllStringSubmatch(op.Path, -1) {
+            parameters = append(parameters, map[string]interface{}{
+                "name": match[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}})
+        }
+        for _, name := range op.Query {
+            parameters = append(parameters, map[string]interface{}{
+                "name": name, "in": "query", "schema": map[string]interface{}{"type": "string"}})
+        }
+        if len(parameters) > 0 {
+            operation["parameters"] = parameters
+        }
+
+        if op.Body != "" {
+            schema := map[string]interface{}{"$ref": "#/components/schemas/" + op.Body}
+            operation["requestBody"] = map[string]interface{}{"content": map[string]interface{}{
+                "application/json": map[string]interface{}{"schema": schema},
+                "application/x-www-form-urlencoded": map[string]interface{}{"schema": schema},
+            }}
+        } else if op.Form {
+            operation["requestBody"] = map[string]interface{}{"content": map[string]interface{}{
+                "multipart/form-data": map[string]interface{}{"schema": map[string]interface{}{
+                    "type": "object", "properties": map[string]interface{}{
+                        "media": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "format": "binary"}},
+                    },
+                }},
+            }}
+        }
+
+        status := op.Status
+        if status == 0 {
+            status = http.StatusOK
+        }
+        response := map[string]interface{}{"description": http.StatusText(status)}
+        if op.Response != "" {
+            response["content"] = map[string]interface{}{"application/json": map[string]interface{}{
+                "schema": map[string]interface{}{"$ref": "#/components/schemas/" + op.Response}}}
+        }
+        responses := map[string]interface{}{strconv.Itoa(status): response}
+        if op.Superuser {
+            operation["security"] = []interface{}{map[string]interface{}{"session": []string{}}, map[string]interface{}{"token": []string{}}}
+            responses["401"] = map[string]interface{}{"description": "Requires a superuser session"}
+        }
+        operation["responses"] = responses
+
+        path := openApiPath(op.Path)
+        if paths[path] == nil {
+            paths[path] = map[string]interface{}{}
+        }
+        paths[path].(map[string]interface{})[strings.ToLower(op.Method)] = operation
+    }
+
+    schemas := envelopeSchemas()
+    for name, t := range apiSchemaTypes {
+        schemas[name] = schemaForType(t)
+    }
+
+    return map[string]interface{}{
+        "openapi": "3.0.3",
+        "info": map[string]interface{}{"title": "marinhobrandao.com CMS API", "version": VERSION},
+        "paths": paths,
+        "components": map[string]interface{}{
+            "schemas": schemas,
+            "securitySchemes": map[string]interface{}{
+                "session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "mbSession"},
+                "token": map[string]interface{}{"type": "http", "scheme": "bearer"},
+            },
+        },
+    }
+}
+
+// Serves the OpenAPI document
+func OpenApiHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    writeJSONCached(c, req, openApiDocument(), "", time.Time{})
+}
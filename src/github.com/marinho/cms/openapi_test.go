@@ -0,0 +1,80 @@
+package cms
+
+import (
+    "strings"
+    "testing"
+    "github.com/gorilla/mux"
+)
+
+// Methods routes take besides the documented ones: HEAD along with GET, and
+// OPTIONS for CORS preflight requests
+var implicitMethods = []string{"HEAD", "OPTIONS"}
+
+// Returns the methods of the routes that are part of the API, by path
+func routedOperations(t *testing.T, r *mux.Router) map[string]bool {
+    documentedPaths := map[string]bool{}
+    for _, op := range apiOperations {
+        documentedPaths[op.Path] = true
+    }
+
+    routed := map[string]bool{}
+    r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+        template, err := route.GetPathTemplate()
+        if err != nil {
+            return nil
+        }
+        path := openApiPath(template)
+        if !strings.HasPrefix(path, "/api/") && !documentedPaths[path] {
+            return nil
+        }
+
+        methods, err := route.GetMethods()
+        if err != nil {
+            t.Errorf("Route %v takes any method", path)
+            return nil
+        }
+        for _, method := range methods {
+            if !containsString(implicitMethods, method) {
+                routed[method + " " + path] = true
+            }
+        }
+        return nil
+    })
+    return routed
+}
+
+func TestRoutesAreDocumented(t *testing.T) {
+    documented := map[string]bool{}
+    for _, op := range apiOperations {
+        documented[op.Method + " " + op.Path] = true
+    }
+
+    for operation := range routedOperations(t, NewRouter()) {
+        if !documented[operation] {
+            t.Errorf("Route %v is not documented", operation)
+        }
+    }
+}
+
+func TestDocumentedOperationsAreRouted(t *testing.T) {
+    routed := routedOperations(t, NewRouter())
+    for _, op := range apiOperations {
+        if !routed[op.Method + " " + op.Path] {
+            t.Errorf("Documented operation %v %v has no route", op.Method, op.Path)
+        }
+    }
+}
+
+func TestDocumentedSchemasExist(t *testing.T) {
+    schemas := envelopeSchemas()
+    for name := range apiSchemaTypes {
+        schemas[name] = true
+    }
+    for _, op := range apiOperations {
+        for _, name := range []string{op.Body, op.Response} {
+            if name != "" && schemas[name] == nil {
+                t.Errorf("%v %v uses unknown schema %v", op.Method, op.Path, name)
+            }
+        }
+    }
+}
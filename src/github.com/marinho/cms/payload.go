@@ -0,0 +1,573 @@
+package cms
+
+import (
+    "time"
+    "errors"
+    "strings"
+    "strconv"
+    "io/ioutil"
+    "net/url"
+    "net/http"
+    "encoding/json"
+    "labix.org/v2/mgo/bson"
+)
+
+// Fields accepted when creating or updating blog posts and pages. Pointers are
+// nil when the field was not sent, so handlers can tell "missing" from "empty".
+type ContentPayload struct {
+    Title *string
+    Content *string
+    Slug *string
+    Tags *[]string
+    Categories *[]string // Category ids, blog posts only
+    PubDate *time.Time
+    Published *bool
+    Seo *SeoFields // JSON only
+}
+
+// Credentials sent to the login handler
+type LoginPayload struct {
+    Username string
+    Password string
+}
+
+// Returns true if the request body is declared as JSON
+func isJSONRequest(req *http.Request) bool {
+    contentType := req.Header.Get("Content-Type")
+    return strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "application/json")
+}
+
+// Splits a comma separated list of tags, dropping empty items
+func splitTags(s string) []string {
+    tags := make([]string,0)
+    for _, tag := range strings.Split(s, ",") {
+        if strings.Trim(tag, " ") != "" {
+            tags = append(tags, strings.Trim(tag, " "))
+        }
+    }
+    return tags
+}
+
+// Trims tags sent as a JSON array, dropping empty items
+func cleanTags(tags []string) []string {
+    cleaned := make([]string,0)
+    for _, tag := range tags {
+        if strings.Trim(tag, " ") != "" {
+            cleaned = append(cleaned, strings.Trim(tag, " "))
+        }
+    }
+    return cleaned
+}
+
+// Reads the request body into the payload, either JSON or form encoded. Form
+// values go to the fields in formFields, by name: strings, comma separated
+// lists, booleans, numbers and RFC 3339 dates, left nil if they're pointers
+// and the value wasn't sent.
+func decodePayload(req *http.Request, payload interface{}, formFields map[string]interface{}) error {
+    body, err := ioutil.ReadAll(req.Body)
+    if err != nil {
+        return err
+    }
+
+    // JSON body with typed fields
+    if isJSONRequest(req) {
+        if err = json.Unmarshal(body, payload); err != nil {
+            return errors.New("Invalid JSON: " + err.Error())
+        }
+        return nil
+    }
+
+    // Form encoded body, kept for backwards compatibility
+    postValues, err := url.ParseQuery(string(body))
+    if err != nil {
+        return err
+    }
+    for name, field := range formFields {
+        sent := len(postValues[name]) > 0
+        value := postValues.Get(name)
+
+        switch field := field.(type) {
+        case *string:
+            *field = value
+        case **string:
+            if sent {
+                *field = &value
+            }
+        case *[]string:
+            *field = splitTags(value)
+        case **[]string:
+            if sent {
+                list := splitTags(value)
+                *field = &list
+            }
+        case **bool:
+            if value != "" {
+                b, err := strconv.ParseBool(value)
+                if err != nil {
+                    return errors.New(name + " must be true or false")
+                }
+                *field = &b
+            }
+        case **int:
+            if value != "" {
+                n, err := strconv.Atoi(value)
+                if err != nil {
+                    return errors.New(name + " must be a number")
+                }
+                *field = &n
+            }
+        case **time.Time:
+            if value != "" {
+                t, err := time.Parse(time.RFC3339, value)
+                if err != nil {
+                    return errors.New(name + " must be in RFC 3339 format")
+                }
+                *field = &t
+            }
+        }
+    }
+    return nil
+}
+
+// Reads a content payload from the request body, either JSON or form encoded
+func parseContentPayload(req *http.Request) (ContentPayload, error) {
+    var payload ContentPayload
+    err := decodePayload(req, &payload, map[string]interface{}{"Title":&payload.Title,
+        "Content":&payload.Content, "Slug":&payload.Slug, "Tags":&payload.Tags,
+        "Categories":&payload.Categories, "PubDate":&payload.PubDate, "Published":&payload.Published})
+    if err != nil {
+        return payload, err
+    }
+
+    if payload.Tags != nil {
+        tags := cleanTags(*payload.Tags)
+        payload.Tags = &tags
+    }
+    if payload.Categories != nil {
+        categories := cleanTags(*payload.Categories)
+        payload.Categories = &categories
+    }
+    if payload.Seo != nil {
+        if err = payload.Seo.validate(); err != nil {
+            return payload, err
+        }
+    }
+    return payload, checkCategoryIds(payload.Categories)
+}
+
+// Returns an error unless all the category ids are valid object ids
+func checkCategoryIds(ids *[]string) error {
+    if ids != nil {
+        for _, id := range *ids {
+            if !bson.IsObjectIdHex(id) {
+                return errors.New("Categories must be category ids")
+            }
+        }
+    }
+    return nil
+}
+
+// Reads login credentials from the request body, either JSON or form encoded
+func parseLoginPayload(req *http.Request) (LoginPayload, error) {
+    var payload LoginPayload
+    err := decodePayload(req, &payload, map[string]interface{}{"Username":&payload.Username, "Password":&payload.Password})
+    return payload, err
+}
+
+// Validates the fields required to save a blog post or a page
+func (payload ContentPayload) validate(requireSlug bool) error {
+    if payload.Title == nil {
+        return errors.New("Title is required")
+    } else if payload.Content == nil {
+        return errors.New("Content is required")
+    } else if requireSlug && payload.Slug == nil {
+        return errors.New("Slug is required")
+    }
+    return nil
+}
+
+// Fills the fields the payload omits with the values new documents get, so
+// PUT replaces the whole document
+func (payload *ContentPayload) fillDefaults() {
+    if payload.Tags == nil {
+        tags := make([]string,0)
+        payload.Tags = &tags
+    }
+    if payload.Categories == nil {
+        categories := make([]string,0)
+        payload.Categories = &categories
+    }
+    if payload.PubDate == nil {
+        now := time.Now()
+        payload.PubDate = &now
+    }
+    if payload.Published == nil {
+        published := true
+        payload.Published = &published
+    }
+    if payload.Seo == nil {
+        payload.Seo = &SeoFields{}
+    }
+}
+
+// Copies the fields present in the payload to a blog post
+func (payload ContentPayload) applyToBlogPost(post *BlogPost) {
+    if payload.Title != nil {
+        post.Title = *payload.Title
+    }
+    if payload.Content != nil {
+        post.Content = *payload.Content
+    }
+    if payload.Slug != nil {
+        post.Slug = *payload.Slug
+    }
+    if payload.Tags != nil {
+        post.Tags = *payload.Tags
+    }
+    if payload.Categories != nil {
+        post.Categories = make([]bson.ObjectId,0)
+        for _, id := range *payload.Categories {
+            post.Categories = append(post.Categories, bson.ObjectIdHex(id))
+        }
+    }
+    if payload.PubDate != nil {
+        post.PubDate = *payload.PubDate
+    }
+    if payload.Published != nil {
+        post.Published = *payload.Published
+    }
+    if payload.Seo != nil {
+        post.Seo = *payload.Seo
+    }
+}
+
+// Copies the fields present in the payload to a page
+func (payload ContentPayload) applyToPage(page *Page) {
+    if payload.Title != nil {
+        page.Title = *payload.Title
+    }
+    if payload.Content != nil {
+        page.Content = *payload.Content
+    }
+    if payload.Slug != nil {
+        page.Slug = *payload.Slug
+    }
+    if payload.Tags != nil {
+        page.Tags = *payload.Tags
+    }
+    if payload.PubDate != nil {
+        page.PubDate = *payload.PubDate
+    }
+    if payload.Published != nil {
+        page.Published = *payload.Published
+    }
+    if payload.Seo != nil {
+        page.Seo = *payload.Seo
+    }
+}
+
+// Copies the fields a photo accepts from the payload
+func (payload ContentPayload) applyToPhoto(photo *Photo) {
+    if payload.Tags != nil {
+        photo.Tags = *payload.Tags
+    }
+    if payload.PubDate != nil {
+        photo.PubDate = *payload.PubDate
+    }
+    if payload.Published != nil {
+        photo.Published = *payload.Published
+    }
+}
+
+// Fields accepted when creating or updating menu items
+type MenuItemPayload struct {
+    Id *string
+    Url *string
+    Label *string
+    Position *int
+}
+
+// Reads a menu item payload from the request body, either JSON or form encoded
+func parseMenuItemPayload(req *http.Request) (MenuItemPayload, error) {
+    var payload MenuItemPayload
+    err := decodePayload(req, &payload, map[string]interface{}{"Id":&payload.Id, "Url":&payload.Url,
+        "Label":&payload.Label, "Position":&payload.Position})
+    return payload, err
+}
+
+// Validates the fields required to save a menu item
+func (payload MenuItemPayload) validate() error {
+    if payload.Url == nil || *payload.Url == "" {
+        return errors.New("Url is required")
+    } else if payload.Label == nil || *payload.Label == "" {
+        return errors.New("Label is required")
+    }
+    return nil
+}
+
+// Fills the fields the payload omits with their defaults, so PUT replaces the
+// whole menu item
+func (payload *MenuItemPayload) fillDefaults() {
+    if payload.Position == nil {
+        position := 0
+        payload.Position = &position
+    }
+}
+
+// Copies the fields present in the payload to a menu item. The Id is only set
+// on creation, as it's the item's key.
+func (payload MenuItemPayload) applyToMenuItem(item *MenuItem) {
+    if payload.Url != nil {
+        item.Url = *payload.Url
+    }
+    if payload.Label != nil {
+        item.Label = *payload.Label
+    }
+    if payload.Position != nil {
+        item.Position = *payload.Position
+    }
+}
+
+// Fields accepted when creating or updating categories
+type CategoryPayload struct {
+    Name *string
+    Slug *string
+    Description *string
+    Parent *string // Id of the parent category, empty for top level ones
+    Position *int
+}
+
+// Reads a category payload from the request body, either JSON or form encoded
+func parseCategoryPayload(req *http.Request) (CategoryPayload, error) {
+    var payload CategoryPayload
+    err := decodePayload(req, &payload, map[string]interface{}{"Name":&payload.Name, "Slug":&payload.Slug,
+        "Description":&payload.Description, "Parent":&payload.Parent, "Position":&payload.Position})
+    return payload, err
+}
+
+// Validates a category payload. Name is required when creating or replacing.
+func (payload CategoryPayload) validate(required bool) error {
+    if required && (payload.Name == nil || *payload.Name == "") {
+        return errors.New("Name is required")
+    } else if payload.Name != nil && *payload.Name == "" {
+        return errors.New("Name can't be empty")
+    } else if payload.Parent != nil && *payload.Parent != "" && !bson.IsObjectIdHex(*payload.Parent) {
+        return errors.New("Parent must be a category id")
+    }
+    return nil
+}
+
+// Copies the fields present in the payload to a category
+func (payload CategoryPayload) applyToCategory(category *Category) {
+    if payload.Name != nil {
+        category.Name = *payload.Name
+    }
+    if payload.Slug != nil {
+        category.Slug = *payload.Slug
+    }
+    if payload.Description != nil {
+        category.Description = *payload.Description
+    }
+    if payload.Parent != nil {
+        category.Parent = ""
+        if *payload.Parent != "" {
+            category.Parent = bson.ObjectIdHex(*payload.Parent)
+        }
+    }
+    if payload.Position != nil {
+        category.Position = *payload.Position
+    }
+}
+
+// Fields accepted when creating or updating webhooks
+type WebhookPayload struct {
+    Url *string
+    Events *[]string
+    Secret *string
+    Active *bool
+}
+
+// Reads a webhook payload from the request body, either JSON or form encoded.
+// Events are a JSON array or a comma separated list.
+func parseWebhookPayload(req *http.Request) (WebhookPayload, error) {
+    var payload WebhookPayload
+    err := decodePayload(req, &payload, map[string]interface{}{"Url":&payload.Url, "Events":&payload.Events,
+        "Secret":&payload.Secret, "Active":&payload.Active})
+    if err == nil && payload.Events != nil {
+        events := cleanTags(*payload.Events)
+        payload.Events = &events
+    }
+    return payload, err
+}
+
+// Validates the fields sent for a webhook. Url and Events are required on
+// creation and replacement, and must be valid whenever they're sent.
+func (payload WebhookPayload) validate(required bool) error {
+    if payload.Url == nil || *payload.Url == "" {
+        if required {
+            return errors.New("Url is required")
+        }
+    }
+    if payload.Url != nil {
+        u, err := url.Parse(*payload.Url)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return errors.New("Url must be an absolute http or https URL")
+        }
+    }
+
+    if payload.Events == nil || len(*payload.Events) == 0 {
+        if required {
+            return errors.New("Events is required")
+        }
+    }
+    if payload.Events != nil {
+        for _, event := range *payload.Events {
+            if !isWebhookEvent(event) {
+                return errors.New("Unknown event: " + event)
+            }
+        }
+    }
+    return nil
+}
+
+// Copies the fields present in the payload to a webhook
+func (payload WebhookPayload) applyToWebhook(hook *Webhook) {
+    if payload.Url != nil {
+        hook.Url = *payload.Url
+    }
+    if payload.Events != nil {
+        hook.Events = *payload.Events
+    }
+    if payload.Secret != nil {
+        hook.Secret = *payload.Secret
+    }
+    if payload.Active != nil {
+        hook.Active = *payload.Active
+    }
+}
+
+// Operation applied to several posts or pages at once. Action is one of
+// publish, unpublish, delete, retag and author.
+type BulkPayload struct {
+    Ids []string
+    Action string
+    Tags *[]string // Replaces the tags, on retag
+    AddTags []string // On retag
+    RemoveTags []string // On retag
+    Author *string // On author
+}
+
+// Reads a bulk operation from the request body, either JSON or form encoded.
+// Ids and tags are JSON arrays or comma separated lists.
+func parseBulkPayload(req *http.Request) (BulkPayload, error) {
+    var payload BulkPayload
+    err := decodePayload(req, &payload, map[string]interface{}{"Ids":&payload.Ids, "Action":&payload.Action,
+        "Tags":&payload.Tags, "AddTags":&payload.AddTags, "RemoveTags":&payload.RemoveTags, "Author":&payload.Author})
+    if err != nil {
+        return payload, err
+    }
+
+    if payload.Tags != nil {
+        tags := cleanTags(*payload.Tags)
+        payload.Tags = &tags
+    }
+    payload.Ids = cleanTags(payload.Ids)
+    payload.AddTags = cleanTags(payload.AddTags)
+    payload.RemoveTags = cleanTags(payload.RemoveTags)
+    return payload, nil
+}
+
+// Validates a bulk operation before any item is changed
+func (payload BulkPayload) validate() error {
+    if len(payload.Ids) == 0 {
+        return errors.New("Ids is required")
+    } else if len(payload.Ids) > MAX_BULK_ITEMS {
+        return errors.New("Too many Ids, the maximum is " + strconv.Itoa(MAX_BULK_ITEMS))
+    }
+
+    switch payload.Action {
+    case "publish", "unpublish", "delete":
+    case "retag":
+        if payload.Tags == nil && len(payload.AddTags) == 0 && len(payload.RemoveTags) == 0 {
+            return errors.New("Tags, AddTags or RemoveTags is required to retag")
+        }
+    case "author":
+        if payload.Author == nil || *payload.Author == "" {
+            return errors.New("Author is required")
+        }
+    case "":
+        return errors.New("Action is required")
+    default:
+        return errors.New("Unknown action: " + payload.Action)
+    }
+    return nil
+}
+
+// Changes the fields of a post or page the operation is about. Delete isn't
+// applied here.
+func (payload BulkPayload) applyToContent(published *bool, tags *[]string, author *string) {
+    switch payload.Action {
+    case "publish":
+        *published = true
+    case "unpublish":
+        *published = false
+    case "retag":
+        if payload.Tags != nil {
+            *tags = *payload.Tags
+        }
+        for _, tag := range payload.AddTags {
+            if !containsString(*tags, tag) {
+                *tags = append(*tags, tag)
+            }
+        }
+        removed := make([]string,0)
+        for _, tag := range payload.RemoveTags {
+            removed = append(removed, Slugify(tag))
+        }
+        kept := make([]string,0)
+        for _, tag := range *tags {
+            if !containsString(removed, Slugify(tag)) {
+                kept = append(kept, tag)
+            }
+        }
+        *tags = kept
+    case "author":
+        *author = *payload.Author
+    }
+}
+
+// Returns true if the list has the value
+func containsString(list []string, value string) bool {
+    for _, item := range list {
+        if item == value {
+            return true
+        }
+    }
+    return false
+}
+
+// Tags to rename or merge, and the name they get
+type TagChangePayload struct {
+    Tags []string // Names or slugs
+    Name string
+}
+
+// Reads a tag change from the request body, either JSON or form encoded. Tags
+// are a JSON array or a comma separated list.
+func parseTagChangePayload(req *http.Request) (TagChangePayload, error) {
+    var payload TagChangePayload
+    err := decodePayload(req, &payload, map[string]interface{}{"Tags":&payload.Tags, "Name":&payload.Name})
+    payload.Tags = cleanTags(payload.Tags)
+    payload.Name = strings.Join(strings.Fields(payload.Name), " ")
+    return payload, err
+}
+
+// Validates a tag change before any document is changed
+func (payload TagChangePayload) validate() error {
+    if len(payload.Tags) == 0 {
+        return errors.New("Tags is required")
+    } else if Slugify(payload.Name) == "" {
+        return errors.New("Name must have letters or digits")
+    }
+    return nil
+}
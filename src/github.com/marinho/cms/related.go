@@ -0,0 +1,179 @@
+package cms
+
+import (
+    "log"
+    "math"
+    "sort"
+    "sync"
+    "time"
+    "strings"
+    "unicode"
+    "net/http"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+// Most related posts suggested for a post
+const RELATED_POSTS = 5
+
+// Shortest word counted when comparing the text of posts
+const RELATED_MIN_WORD_LENGTH = 4
+
+// Related posts computed for each post, until content changes
+var relatedCache = struct {
+    sync.Mutex
+    posts map[bson.ObjectId][]PostLink
+}{posts:map[bson.ObjectId][]PostLink{}}
+
+// Published post loaded to be compared with others
+type relatedCandidate struct {
+    Id bson.ObjectId `bson:"_id"`
+    Slug string
+    Title string
+    PubDate time.Time
+    TagSlugs []string
+    SearchText string
+}
+
+// Returns how many times each word of the text appears, skipping short words
+func wordCounts(text string) map[string]float64 {
+    counts := make(map[string]float64)
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+    })
+    for _, word := range words {
+        if len([]rune(word)) >= RELATED_MIN_WORD_LENGTH {
+            counts[word]++
+        }
+    }
+    return counts
+}
+
+// Returns the cosine similarity of two word counts, from 0 to 1
+func cosineSimilarity(a map[string]float64, b map[string]float64) float64 {
+    var dot, normA, normB float64
+    for word, count := range a {
+        dot += count * b[word]
+        normA += count * count
+    }
+    for _, count := range b {
+        normB += count * count
+    }
+    if normA == 0 || normB == 0 {
+        return 0
+    }
+    return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Returns the share of tags two posts have in common, from 0 to 1
+func sharedTags(a []string, b []string) float64 {
+    shared := 0
+    for _, slug := range a {
+        if containsString(b, slug) {
+            shared++
+        }
+    }
+    total := len(a) + len(b) - shared
+    if total == 0 {
+        return 0
+    }
+    return float64(shared) / float64(total)
+}
+
+// Computes the published posts most related to a post, by the tags they share
+// and the similarity of their text, both weighing the same
+func computeRelatedPosts(db *mgo.Database, post BlogPost) ([]PostLink, error) {
+    var candidates []relatedCandidate
+    err := db.C(BLOG_POST_COLL_NAME).Find(bson.M{"published":true, "_id":bson.M{"$ne":post.Id}}).
+        Select(bson.M{"slug":1, "title":1, "pubdate":1, "tagslugs":1, "searchtext":1}).All(&candidates)
+    if err != nil {
+        return nil, err
+    }
+
+    type scored struct {
+        candidate relatedCandidate
+        score float64
+    }
+    words := wordCounts(post.Title + " " + post.SearchText)
+    matches := make([]scored,0)
+    for _, candidate := range candidates {
+        score := sharedTags(post.TagSlugs, candidate.TagSlugs) + cosineSimilarity(words, wordCounts(candidate.Title + " " + candidate.SearchText))
+        if score > 0 {
+            matches = append(matches, scored{candidate, score})
+        }
+    }
+    // Most related first, then the newest
+    sort.SliceStable(matches, func(i, j int) bool {
+        if matches[i].score != matches[j].score {
+            return matches[i].score > matches[j].score
+        }
+        return matches[i].candidate.PubDate.After(matches[j].candidate.PubDate)
+    })
+
+    related := make([]PostLink,0)
+    for i := 0; i < len(matches) && i < RELATED_POSTS; i++ {
+        candidate := matches[i].candidate
+        related = append(related, *postLink(&BlogPost{Id:candidate.Id, Slug:candidate.Slug, Title:candidate.Title, PubDate:candidate.PubDate}))
+    }
+    return related, nil
+}
+
+// Returns the posts related to a post, computing them unless they are cached.
+// Any content change clears the cache, as it may change which posts relate.
+func GetRelatedPosts(db *mgo.Database, post BlogPost) ([]PostLink, error) {
+    relatedCache.Lock()
+    related, ok := relatedCache.posts[post.Id]
+    relatedCache.Unlock()
+    if ok {
+        return related, nil
+    }
+
+    related, err := computeRelatedPosts(db, post)
+    if err == nil {
+        relatedCache.Lock()
+        relatedCache.posts[post.Id] = related
+        relatedCache.Unlock()
+    }
+    return related, err
+}
+
+// Empties the cache of related posts
+func clearRelatedPosts() {
+    relatedCache.Lock()
+    relatedCache.posts = map[bson.ObjectId][]PostLink{}
+    relatedCache.Unlock()
+}
+
+/* HANDLERS */
+
+// Posts related to a published post, or to any post for superusers
+func RelatedPostsHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        methodNotAllowed(c, "GET", "HEAD")
+        return
+    }
+
+    db := dbDefaultConn.DB(systemConf.DBName)
+    post, err := GetBlogPost(db, mux.Vars(req)["postId"])
+    if err == mgo.ErrNotFound || (err == nil && !post.Published && !IsSuperuser(c, req)) {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+
+    var related []PostLink
+    if err == nil {
+        related, err = GetRelatedPosts(db, post)
+    }
+    if err != nil {
+        writeJSONError(c, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSONCached(c, req, map[string]interface{}{"posts":related}, "", time.Time{})
+}
+
+// Registers the related posts routes
+func setRelatedUrls(r *mux.Router) {
+    r.HandleFunc(API_V2_PREFIX + "/posts/{postId:" + OBJECT_ID_PATTERN + "}/related/", RelatedPostsHandler).Methods("GET", "HEAD", "OPTIONS")
+}
@@ -0,0 +1,310 @@
+package cms
+
+import (
+    "log"
+    "sort"
+    "time"
+    "errors"
+    "regexp"
+    "strings"
+    "net/http"
+    "html"
+    "html/template"
+    "unicode/utf8"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+// Characters of text around the first match shown with each result
+const SEARCH_SNIPPET_LENGTH = 200
+
+// Name of the text index of posts and pages
+const SEARCH_INDEX_NAME = "search"
+
+// A post or page matching a search, with a snippet of its text where the terms
+// are wrapped in <mark> elements
+type SearchResult struct {
+    Type string // "post" or "page"
+    Id bson.ObjectId
+    Title string
+    Url string
+    PubDate time.Time
+    Snippet template.HTML
+    Score float64
+}
+
+// Database fields results can be sorted by. Score is the relevance.
+var searchSortFields = map[string]string{"score":"score", "pubdate":"pubdate"}
+
+// Creates the text indexes of posts and pages, where titles weigh more than
+// tags and tags more than the text
+func ensureSearchIndexes(db *mgo.Database) error {
+    for _, collName := range []string{BLOG_POST_COLL_NAME, PAGE_COLL_NAME} {
+        index := bson.M{"name":SEARCH_INDEX_NAME,
+            "key":bson.M{"title":"text", "tags":"text", "searchtext":"text"},
+            "weights":bson.M{"title":10, "tags":5, "searchtext":1}}
+        command := bson.D{{Name:"createIndexes", Value:collName}, {Name:"indexes", Value:[]bson.M{index}}}
+        if err := db.Run(command, nil); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Fills the text to search of posts and pages saved before search existed
+func IndexStoredContent(db *mgo.Database) error {
+    for _, collName := range []string{BLOG_POST_COLL_NAME, PAGE_COLL_NAME} {
+        coll := db.C(collName)
+        var doc struct {
+            Id bson.ObjectId `bson:"_id"`
+            Content string
+        }
+        iter := coll.Find(bson.M{"searchtext":bson.M{"$exists":false}}).Select(bson.M{"content":1}).Iter()
+        for iter.Next(&doc) {
+            if err := coll.UpdateId(doc.Id, bson.M{"$set":bson.M{"searchtext":plainText(doc.Content)}}); err != nil {
+                iter.Close()
+                return err
+            }
+        }
+        if err := iter.Close(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Returns the pattern matching the words of a query, and words starting with
+// them as the index matches variations of words. Negated words are left out.
+func searchTermsPattern(query string) *regexp.Regexp {
+    terms := make([]string,0)
+    for _, word := range strings.Fields(strings.Replace(query, "\"", " ", -1)) {
+        if !strings.HasPrefix(word, "-") {
+            terms = append(terms, regexp.QuoteMeta(word))
+        }
+    }
+    if len(terms) == 0 {
+        return nil
+    }
+    return regexp.MustCompile(`(?i)\b(?:` + strings.Join(terms, "|") + `)\w*`)
+}
+
+// Returns the part of the text around the first match of the pattern, escaped
+// to HTML and with the matches marked
+func highlightSnippet(text string, pattern *regexp.Regexp, length int) string {
+    start := 0
+    if pattern != nil {
+        if match := pattern.FindStringIndex(text); match != nil {
+            // Some words before the match, for context
+            before := []rune(text[:match[0]])
+            if len(before) > length / 4 {
+                start = match[0] - len(string(before[len(before)-length/4:]))
+                if i := strings.Index(text[start:match[0]], " "); i >= 0 {
+                    start += i + 1
+                }
+            }
+        }
+    }
+
+    snippet := text[start:]
+    if utf8.RuneCountInString(snippet) > length {
+        snippet = truncateText(snippet, length)
+    }
+    prefix := ""
+    if start > 0 {
+        prefix = "…"
+    }
+    if pattern == nil {
+        return prefix + html.EscapeString(snippet)
+    }
+
+    var marked strings.Builder
+    marked.WriteString(prefix)
+    last := 0
+    for _, match := range pattern.FindAllStringIndex(snippet, -1) {
+        marked.WriteString(html.EscapeString(snippet[last:match[0]]))
+        marked.WriteString("<mark>" + html.EscapeString(snippet[match[0]:match[1]]) + "</mark>")
+        last = match[1]
+    }
+    marked.WriteString(html.EscapeString(snippet[last:]))
+    return marked.String()
+}
+
+// Returns a page of the posts and pages matching the query, of the types given,
+// with the total of matches. The filters of the options apply too, and
+// searching a category leaves pages out.
+func Search(db *mgo.Database, query string, types []string, opts ListOptions) ([]SearchResult, int, error) {
+    results := make([]SearchResult,0)
+    if opts.Category != "" {
+        ids, err := categoryTreeIds(db, opts.Category)
+        if err == mgo.ErrNotFound {
+            return results, 0, nil
+        } else if err != nil {
+            return results, 0, err
+        }
+        opts.categoryIds = ids
+        types = []string{"post"}
+    }
+
+    collections := map[string]string{"post":BLOG_POST_COLL_NAME, "page":PAGE_COLL_NAME}
+    for _, kind := range types {
+        filter := opts.filter()
+        filter["$text"] = bson.M{"$search":query}
+        var matches []struct {
+            Id bson.ObjectId `bson:"_id"`
+            Title string
+            Slug string
+            PubDate time.Time
+            Score float64
+        }
+        err := db.C(collections[kind]).Find(filter).
+            Select(bson.M{"title":1, "slug":1, "pubdate":1, "score":bson.M{"$meta":"textScore"}}).All(&matches)
+        if err != nil {
+            return results, 0, err
+        }
+
+        for _, match := range matches {
+            result := SearchResult{Type:kind, Id:match.Id, Title:match.Title, PubDate:match.PubDate, Score:match.Score}
+            if kind == "post" {
+                result.Url = BlogPost{Slug:match.Slug, PubDate:match.PubDate}.Url()
+            } else {
+                result.Url = "/" + match.Slug
+            }
+            results = append(results, result)
+        }
+    }
+
+    // Most relevant first, unless sorted by date
+    sortField := "-score"
+    if len(opts.Sort) > 0 {
+        sortField = opts.Sort[0]
+    }
+    sort.SliceStable(results, func(i, j int) bool {
+        switch sortField {
+        case "pubdate":
+            return results[i].PubDate.Before(results[j].PubDate)
+        case "-pubdate":
+            return results[i].PubDate.After(results[j].PubDate)
+        case "score":
+            return results[i].Score < results[j].Score
+        }
+        return results[i].Score > results[j].Score
+    })
+
+    total := len(results)
+    if opts.Limit > 0 {
+        start := (opts.Page - 1) * opts.Limit
+        if start > total {
+            start = total
+        }
+        end := start + opts.Limit
+        if end > total {
+            end = total
+        }
+        results = results[start:end]
+    }
+
+    // Snippets only for the results of the page
+    pattern := searchTermsPattern(query)
+    for i := range results {
+        var doc struct {
+            SearchText string
+        }
+        err := db.C(collections[results[i].Type]).FindId(results[i].Id).Select(bson.M{"searchtext":1}).One(&doc)
+        if err != nil {
+            return results, total, err
+        }
+        results[i].Snippet = template.HTML(highlightSnippet(doc.SearchText, pattern, SEARCH_SNIPPET_LENGTH))
+    }
+    return results, total, nil
+}
+
+// Reads the query and the types to search from the request
+func parseSearchQuery(req *http.Request) (string, []string, error) {
+    query := strings.TrimSpace(req.URL.Query().Get("q"))
+    if query == "" {
+        return query, nil, errors.New("q is required")
+    }
+
+    types := []string{"post", "page"}
+    if value := req.URL.Query().Get("type"); value != "" {
+        if value != "post" && value != "page" {
+            return query, nil, errors.New("type must be post or page")
+        }
+        types = []string{value}
+    }
+    return query, types, nil
+}
+
+/* HANDLERS */
+
+// Searches published posts and pages, or all for superusers with
+// published=all. Results are paginated, sorted by relevance or date.
+func SearchHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        methodNotAllowed(c, "GET", "HEAD")
+        return
+    }
+
+    query, types, err := parseSearchQuery(req)
+    var opts ListOptions
+    if err == nil {
+        opts, err = parseListOptions(c, req, searchSortFields, "-score", DEFAULT_PAGE_LIMIT)
+    }
+    if err != nil {
+        writeJSONError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    results, total, err := Search(dbDefaultConn.DB(systemConf.DBName), query, types, opts)
+    if err != nil {
+        writeJSONError(c, http.StatusInternalServerError, err.Error())
+        return
+    }
+    setPaginationHeaders(c, req, opts, total)
+    writeJSONCached(c, req, map[string]interface{}{"query":query, "results":results, "total":total,
+        "page":opts.Page, "limit":opts.Limit}, "", time.Time{})
+}
+
+// Search page, with the first page of results when there is a query
+func SearchViewHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        http.Error(c, "Invalid method.", http.StatusMethodNotAllowed)
+        return
+    }
+
+    query := strings.TrimSpace(req.URL.Query().Get("q"))
+    results := make([]SearchResult,0)
+    total := 0
+    var err error
+    if query != "" {
+        published := true
+        opts := ListOptions{Page:1, Limit:DEFAULT_PAGE_LIMIT, Published:&published}
+        results, total, err = Search(dbDefaultConn.DB(systemConf.DBName), query, []string{"post", "page"}, opts)
+    }
+    if err == nil {
+        var data string
+        data, err = renderServerTemplate("search.html", map[string]interface{}{
+            "Query": query,
+            "Results": results,
+            "Total": total,
+        })
+        if err == nil {
+            c.Header().Set("Content-Type", "text/html; charset=utf-8")
+            writeCached(c, req, data, time.Time{})
+            return
+        }
+    }
+
+    log.Println(err)
+    http.Error(c, "Server error", http.StatusInternalServerError)
+}
+
+// Registers the search routes
+func setSearchUrls(r *mux.Router) {
+    r.HandleFunc("/api/search/", SearchHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/search", SearchViewHandler)
+    r.HandleFunc("/search/", SearchViewHandler)
+}
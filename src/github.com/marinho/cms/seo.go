@@ -0,0 +1,193 @@
+package cms
+
+import (
+    "bytes"
+    "errors"
+    "regexp"
+    "strings"
+    "time"
+    "net/url"
+    "net/http"
+    "html"
+    "html/template"
+    "path/filepath"
+    "unicode/utf8"
+    "github.com/russross/blackfriday"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+// Longest description taken from the content, in characters
+const SEO_DESCRIPTION_LENGTH = 160
+
+// Search engine and social network fields of a post or page. Empty fields fall
+// back to the content's own.
+type SeoFields struct {
+    Title string // Instead of the title, in the page's title and when shared
+    Description string
+    Image string // Id of the photo shown when shared, instead of the first in the content
+    Canonical string // Absolute address, when the content was first published elsewhere
+    NoIndex bool // Asks search engines not to index it, and leaves it out of the sitemap
+}
+
+// Metadata rendered in the head of a post or page
+type seoMeta struct {
+    Title string
+    Description string
+    Image string
+    Url string // Canonical address
+    NoIndex bool
+    Type string // OpenGraph type, "article" or "website"
+    SiteName string
+    JsonLd []interface{} // Structured data, encoded as JSON-LD
+}
+
+// One step of a breadcrumb trail, with an absolute address
+type breadcrumb struct {
+    Name string
+    Url string
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// Returns the text of content in Markdown, without markup or shortcodes and
+// with its spaces collapsed
+func plainText(content string) string {
+    // Without highlighting, whose line numbers would be taken as text
+    text := htmlTagPattern.ReplaceAllString(string(blackfriday.MarkdownCommon([]byte(stripShortcodes(content)))), " ")
+    return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// Returns the text cut at a word to at most max characters, with an ellipsis
+// if it was cut
+func truncateText(text string, max int) string {
+    if utf8.RuneCountInString(text) <= max {
+        return text
+    }
+    cut := string([]rune(text)[:max-1])
+    if i := strings.LastIndex(cut, " "); i > 0 {
+        cut = cut[:i]
+    }
+    return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// Returns an error unless the fields can be saved: the image must be a photo id
+// and the canonical address absolute
+func (fields SeoFields) validate() error {
+    if fields.Image != "" && !bson.IsObjectIdHex(fields.Image) {
+        return errors.New("Seo.Image must be a photo id")
+    }
+    if fields.Canonical != "" {
+        u, err := url.Parse(fields.Canonical)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return errors.New("Seo.Canonical must be an absolute address")
+        }
+    }
+    return nil
+}
+
+// Returns the absolute address of the photo chosen for the content, or of the
+// first one it shows
+func seoImage(db *mgo.Database, req *http.Request, fields SeoFields, content string) (string, error) {
+    if fields.Image != "" {
+        photo, err := GetPhoto(db, fields.Image)
+        if err == nil {
+            return siteUrl(req) + "/static/photos/" + photo.Filename, nil
+        } else if err != mgo.ErrNotFound {
+            return "", err
+        }
+    }
+
+    photos, err := contentPhotos(db, content)
+    if err != nil || len(photos) == 0 {
+        return "", err
+    }
+    return siteUrl(req) + "/static/photos/" + photos[0].Filename, nil
+}
+
+// Returns the metadata with the fields of the content, falling back to the
+// title and the start of the text
+func newSeoMeta(db *mgo.Database, req *http.Request, fields SeoFields, title string, content string, path string) (seoMeta, error) {
+    meta := seoMeta{Title:fields.Title, Description:fields.Description, Url:fields.Canonical,
+        NoIndex:fields.NoIndex, Type:"website", SiteName:siteTitle(req), JsonLd:make([]interface{},0)}
+    if meta.Title == "" {
+        meta.Title = title
+    }
+    if meta.Description == "" {
+        meta.Description = truncateText(plainText(content), SEO_DESCRIPTION_LENGTH)
+    }
+    if meta.Url == "" {
+        meta.Url = siteUrl(req) + path
+    }
+
+    var err error
+    meta.Image, err = seoImage(db, req, fields, content)
+    return meta, err
+}
+
+// Returns the schema.org BreadcrumbList of a trail
+func breadcrumbList(trail []breadcrumb) map[string]interface{} {
+    items := make([]map[string]interface{},0)
+    for i, crumb := range trail {
+        items = append(items, map[string]interface{}{"@type":"ListItem", "position":i + 1, "name":crumb.Name, "item":crumb.Url})
+    }
+    return map[string]interface{}{"@context":"https://schema.org", "@type":"BreadcrumbList", "itemListElement":items}
+}
+
+// Returns the metadata of a post, as an Article under the path of its first
+// category
+func postSeoMeta(db *mgo.Database, req *http.Request, post BlogPost, categories []Category) (seoMeta, error) {
+    meta, err := newSeoMeta(db, req, post.Seo, post.Title, post.Content, post.Url())
+    if err != nil {
+        return meta, err
+    }
+    meta.Type = "article"
+
+    article := map[string]interface{}{"@context":"https://schema.org", "@type":"Article",
+        "headline":meta.Title, "description":meta.Description, "mainEntityOfPage":meta.Url,
+        "datePublished":post.PubDate.UTC().Format(time.RFC3339), "dateModified":post.LastModified().UTC().Format(time.RFC3339)}
+    if post.Author != "" {
+        article["author"] = map[string]interface{}{"@type":"Person", "name":post.Author}
+    }
+    if meta.Image != "" {
+        article["image"] = meta.Image
+    }
+
+    trail := []breadcrumb{{Name:meta.SiteName, Url:siteUrl(req) + "/"}}
+    if len(categories) > 0 {
+        all, err := ListCategories(db)
+        if err != nil {
+            return meta, err
+        }
+        for _, category := range categoryPath(all, categories[0]) {
+            trail = append(trail, breadcrumb{Name:category.Name, Url:siteUrl(req) + category.Url()})
+        }
+    }
+    trail = append(trail, breadcrumb{Name:post.Title, Url:siteUrl(req) + post.Url()})
+
+    meta.JsonLd = append(meta.JsonLd, article, breadcrumbList(trail))
+    return meta, nil
+}
+
+// Returns the metadata of a page
+func pageSeoMeta(db *mgo.Database, req *http.Request, page Page) (seoMeta, error) {
+    meta, err := newSeoMeta(db, req, page.Seo, page.Title, page.Content, "/" + page.Slug)
+    if err == nil {
+        trail := []breadcrumb{{Name:meta.SiteName, Url:siteUrl(req) + "/"}, {Name:page.Title, Url:siteUrl(req) + "/" + page.Slug}}
+        meta.JsonLd = append(meta.JsonLd, breadcrumbList(trail))
+    }
+    return meta, err
+}
+
+// Renders the title, meta tags and structured data of the metadata, for the
+// head of pages that aren't rendered with a server template
+func renderSeoHead(meta seoMeta) (string, error) {
+    tmpl, err := template.ParseFiles(filepath.Join(systemConf.TemplatesRoot, "server", "seo.html"))
+    if err != nil {
+        return "", err
+    }
+
+    var head bytes.Buffer
+    err = tmpl.ExecuteTemplate(&head, "seo", meta)
+    return head.String(), err
+}
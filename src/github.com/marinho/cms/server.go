@@ -2,6 +2,7 @@ package cms
 
 import (
     "fmt"
+    "time"
     "log"
     "io"
     "io/ioutil"
@@ -10,10 +11,12 @@ import (
     "encoding/json"
     "path/filepath"
     "net/http"
-    "net/url"
+    "mime/multipart"
     "strconv"
     "errors"
     "strings"
+    "regexp"
+    "crypto/subtle"
     "labix.org/v2/mgo"
     "github.com/gorilla/mux"
     "github.com/gorilla/sessions"
@@ -29,12 +32,6 @@ var sessionStore = sessions.NewCookieStore([]byte("mbSessionId"))
 
 /* Configuration and parameters */
 
-type MenuItem struct {
-    Url string
-    Id string
-    Label string
-}
-
 type Configuration struct {
     DBHostname string
     DBName string
@@ -43,8 +40,16 @@ type Configuration struct {
     PhotosRoot string
     TemplatesRoot string
     AuthSecret string
+    ApiToken string // Grants superuser access to "Authorization: Bearer" requests, if not empty
+    PublicCors CorsPolicy // Reading the API from other origins
+    AdminCors CorsPolicy // Changes and the admin API from other origins
     AdminUsername string
     AdminPassword string
+    PostPermalinks string // "slug" for /blog/{slug}/, or "date" for /blog/{year}/{month}/{day}/{slug}/
+    SiteUrl string // Like "https://example.com", for absolute links. Defaults to the request's host.
+    SiteTitle string // Title of feeds. Defaults to the host.
+    RobotsTxt string // Rules of robots.txt, which links to the sitemap. By default /admin/ and /api/ are disallowed.
+    HighlightStyle string // Chroma style of code blocks, "github" by default
 }
 var systemConf Configuration
 
@@ -120,13 +125,12 @@ func showHelp() {
 // Content URL handlers
 
 func renderTemplate(templateName string) (string, error) {
-    var err error
-
     // Base template
-    base_content, err := ioutil.ReadFile(filepath.Join(systemConf.TemplatesRoot,"base.html"))
-    if err != nil {
-        return "", errors.New("Couldn't load base.html")
-    } else if templateName == "base.html" {
+    if templateName == "base.html" {
+        base_content, err := ioutil.ReadFile(filepath.Join(systemConf.TemplatesRoot,"base.html"))
+        if err != nil {
+            return "", errors.New("Couldn't load base.html")
+        }
         return string(base_content), nil
     }
 
@@ -136,7 +140,35 @@ func renderTemplate(templateName string) (string, error) {
         return "", errors.New("Couldn't load " + templateName)
     }
 
-    return strings.Replace(string(base_content), "<!-- CONTENT -->", string(content), 1), nil
+    return renderWithContent(string(content))
+}
+
+// Renders base.html with the content in place of its placeholder
+func renderWithContent(content string) (string, error) {
+    return renderWithHead(content, "")
+}
+
+// Renders base.html with the content and extra elements of its head, like
+// links to feeds, in place of their placeholders
+func renderWithHead(content string, head string) (string, error) {
+    base_content, err := ioutil.ReadFile(filepath.Join(systemConf.TemplatesRoot,"base.html"))
+    if err != nil {
+        return "", errors.New("Couldn't load base.html")
+    }
+
+    page := withHead(string(base_content), head)
+    return strings.Replace(page, "<!-- CONTENT -->", content, 1), nil
+}
+
+var titlePattern = regexp.MustCompile(`(?s)<title>.*?</title>`)
+
+// Puts the elements in the head placeholder of the page. A title among them
+// replaces the page's own.
+func withHead(page string, head string) string {
+    if strings.Contains(head, "<title>") {
+        page = titlePattern.ReplaceAllLiteralString(page, "")
+    }
+    return strings.Replace(page, "<!-- HEAD -->", head, 1)
 }
 
 func renderAdminTemplate(templateName string) (string, error) {
@@ -170,8 +202,7 @@ func IsSuperuserHandler(c http.ResponseWriter, req *http.Request) {
     c.Header().Add("Content-Type", "text/plain")
 
     // Get current session
-    session, err := GetSession(c, req)
-    if err != nil || session.Values["secret"] != systemConf.AuthSecret {
+    if !IsSuperuser(c, req) {
         data = "no"
     } else {
         data = "yes"
@@ -182,12 +213,31 @@ func IsSuperuserHandler(c http.ResponseWriter, req *http.Request) {
 
 }
 
+// Returns true if the request carries the configured API token
+func hasApiToken(req *http.Request) bool {
+    header := req.Header.Get("Authorization")
+    if systemConf.ApiToken == "" || !strings.HasPrefix(header, "Bearer ") {
+        return false
+    }
+    token := strings.TrimPrefix(header, "Bearer ")
+    return subtle.ConstantTimeCompare([]byte(token), []byte(systemConf.ApiToken)) == 1
+}
+
+// Returns true if the current session is authenticated as superuser, or the
+// request carries the API token
+func IsSuperuser(c http.ResponseWriter, req *http.Request) bool {
+    if hasApiToken(req) {
+        return true
+    }
+    session, err := GetSession(c, req)
+    return err == nil && session.Values["secret"] == systemConf.AuthSecret
+}
+
 // Decorator for URL handlers whose require superuser authentication
 func RequireSuperuser(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
     return func (c http.ResponseWriter, req *http.Request) {
-        // Gets the current session
-        session, err := GetSession(c, req)
-        if err != nil || session.Values["secret"] != systemConf.AuthSecret {
+        // Checks the current session
+        if !IsSuperuser(c, req) {
             // Return error
             http.Error(c, "Unauthorized", http.StatusUnauthorized)
             return
@@ -198,6 +248,19 @@ func RequireSuperuser(handler func(http.ResponseWriter, *http.Request)) func(htt
     }
 }
 
+// Redirects to the path, keeping the query string. Methods other than GET
+// and HEAD get a 308, so clients repeat them with the same body.
+func redirectPermanently(c http.ResponseWriter, req *http.Request, path string) {
+    if req.URL.RawQuery != "" {
+        path += "?" + req.URL.RawQuery
+    }
+    status := http.StatusMovedPermanently
+    if req.Method != "GET" && req.Method != "HEAD" {
+        status = http.StatusPermanentRedirect
+    }
+    http.Redirect(c, req, path, status)
+}
+
 // Home page handler using template home.html
 func HomeHandler(c http.ResponseWriter, req *http.Request) {
     log.Println(req.URL)
@@ -215,8 +278,7 @@ func HomeHandler(c http.ResponseWriter, req *http.Request) {
         c.Header().Add("Content-Length", strconv.Itoa(len("Failed")))
         io.WriteString(c, "Failed")
     } else {
-        c.Header().Add("Content-Length", strconv.Itoa(len(content)))
-        io.WriteString(c, content)
+        writeCached(c, req, content, time.Time{})
     }
 }
 
@@ -230,19 +292,15 @@ func LoginHandler(c http.ResponseWriter, req *http.Request) {
     c.Header().Add("Content-Type", "text/json")
 
     // Validates user
-    body, err := ioutil.ReadAll(req.Body)
+    credentials, err := parseLoginPayload(req)
     if err == nil {
-        postValues, err := url.ParseQuery(string(body))
-        if err == nil {
-            // User validation
-            if len(postValues["Username"]) > 0 && postValues["Username"][0] == systemConf.AdminUsername &&
-               len(postValues["Password"]) > 0 && postValues["Password"][0] == systemConf.AdminPassword {
-                // Starts a session
-                session, err = GetSession(c, req)
-                if err == nil {
-                    session.Values["secret"] = systemConf.AuthSecret
-                    session.Save(req, c)
-                }
+        // User validation
+        if credentials.Username == systemConf.AdminUsername && credentials.Password == systemConf.AdminPassword {
+            // Starts a session
+            session, err = GetSession(c, req)
+            if err == nil {
+                session.Values["secret"] = systemConf.AuthSecret
+                session.Save(req, c)
             }
         }
     }
@@ -277,15 +335,12 @@ func MenuItemsHandler(c http.ResponseWriter, req *http.Request) {
 
     c.Header().Add("Content-Type", "text/json")
 
-    // Menu items list - TODO: move this to database and create a function to load fixtures
-    menuItemsList := make([]MenuItem,0)
-    menuItemsList = append(menuItemsList, MenuItem{Url:"/", Id:"menu-home", Label:"Home"})
-    menuItemsList = append(menuItemsList, MenuItem{Url:"/real-life/", Id:"menu-life", Label:"Real life"})
-    menuItemsList = append(menuItemsList, MenuItem{Url:"/legacy/", Id:"menu-legacy", Label:"Legacy"})
-    menuItemsList = append(menuItemsList, MenuItem{Url:"http://github.com/marinho", Id:"menu-github", Label:"Github"})
-    menuItemsList = append(menuItemsList, MenuItem{Url:"http://old.marinhobrandao.com/", Id:"menu-old", Label:"Old site"})
-    menuItemsList = append(menuItemsList, MenuItem{Url:"https://plus.google.com/108430754321695774288/posts", Id:"menu-gplus", Label:"Google+"})
-    menuItemsList = append(menuItemsList, MenuItem{Url:"http://de.linkedin.com/in/marinhobrandao", Id:"menu-linkedin", Label:"Linkedin"})
+    // Menu items list
+    menuItemsList, err := ListMenuItems(dbDefaultConn.DB(systemConf.DBName))
+    if err != nil {
+        log.Println(err)
+        menuItemsList = make([]MenuItem,0)
+    }
 
     // Encoding to JSON
     b, err := json.Marshal(menuItemsList)
@@ -308,19 +363,26 @@ func BlogPostListHandler(c http.ResponseWriter, req *http.Request) {
     c.Header().Add("Content-Type", "text/json")
 
     // Posts from database
-    blogPostsList, err := GetRecentBlogPosts(dbDefaultConn.DB(systemConf.DBName))
+    opts, err := parseListOptions(c, req, blogPostSortFields, "-pubdate", 0)
+    if err != nil {
+        http.Error(c, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
+        return
+    }
+    blogPostsList, total, err := FindBlogPosts(dbDefaultConn.DB(systemConf.DBName), opts)
     if err == nil {
+        excerptPosts(req, blogPostsList)
+
         // Encoding to JSON
         b, err := json.Marshal(blogPostsList)
         if err == nil {
-            data = "{\"posts\":" + string(b) + "}"
+            data = fmt.Sprintf("{\"posts\":%v, \"total\":%v, \"page\":%v, \"limit\":%v}", string(b), total, opts.Page, opts.Limit)
+            setPaginationHeaders(c, req, opts, total)
         } else {
             fmt.Println("error:", err)
         }
     }
 
-    c.Header().Add("Content-Length", strconv.Itoa(len(data)))
-    io.WriteString(c, data)
+    writeCached(c, req, data, time.Time{})
 }
 
 // Handler to add a new blog post, for the API
@@ -330,7 +392,7 @@ func BlogPostAddHandler(c http.ResponseWriter, req *http.Request) {
     var data string
     var err error
     var blogPost BlogPost
-    tags := make([]string,0)
+    var payload ContentPayload
 
     // Method not allowed
     if req.Method != "POST" {
@@ -339,31 +401,17 @@ func BlogPostAddHandler(c http.ResponseWriter, req *http.Request) {
     }
 
     // Save the new post
-    body, err := ioutil.ReadAll(req.Body)
+    payload, err = parseContentPayload(req)
     if err == nil {
-        postValues, err := url.ParseQuery(string(body))
-        if err == nil {
-            if len(postValues["Title"]) == 0 {
-                err = errors.New("Title is required")
-            } else if len(postValues["Content"]) == 0 {
-                err = errors.New("Content is required")
-            } else {
-                title := postValues["Title"][0]
-                content := postValues["Content"][0]
-                slug := Slugify(title)
-                if len(postValues["Tags"]) > 0 {
-                    tags2 := strings.Split(postValues["Tags"][0], ",")
-                    for iTag := range tags2 {
-                        if strings.Trim(tags2[iTag], " ") != "" {
-                            tags = append(tags, strings.Trim(tags2[iTag], " "))
-                        }
-                    }
-                }
-
-                blogPost = BlogPost{Title:title, Content:content, Published:true, Slug:slug, Author:DEFAULT_AUTHOR, Tags:tags}
-                err = InsertNewBlogPost(dbDefaultConn.DB(systemConf.DBName), &blogPost)
-            }
+        err = payload.validate(false)
+    }
+    if err == nil {
+        blogPost = BlogPost{Published:true, Author:DEFAULT_AUTHOR, Tags:make([]string,0)}
+        payload.applyToBlogPost(&blogPost)
+        if payload.Slug == nil || *payload.Slug == "" {
+            blogPost.Slug = Slugify(blogPost.Title)
         }
+        err = InsertNewBlogPost(dbDefaultConn.DB(systemConf.DBName), &blogPost)
     }
 
     if err == nil {
@@ -381,23 +429,61 @@ func BlogPostInfoHandler(c http.ResponseWriter, req *http.Request) {
     c.Header().Add("Content-Type", "text/json")
     var post BlogPost
     var err error
-    tags := make([]string,0)
     data := "{\"result\":\"error\"}"
 
     // Parse arguments
     args := mux.Vars(req)
 
-    // Loading blog post
-    post, err = GetBlogPost(dbDefaultConn.DB(systemConf.DBName), args["postId"])
+    // Loading blog post, old slugs are redirected to the current one
+    if args["postSlug"] != "" {
+        post, err = GetBlogPostBySlug(dbDefaultConn.DB(systemConf.DBName), args["postSlug"])
+        if err != nil {
+            if post, err = GetBlogPostByOldSlug(dbDefaultConn.DB(systemConf.DBName), args["postSlug"]); err == nil && (post.Published || IsSuperuser(c, req)) {
+                redirectPermanently(c, req, "/api/blog/post/by-slug/" + post.Slug + "/")
+                return
+            }
+        }
+    } else {
+        post, err = GetBlogPost(dbDefaultConn.DB(systemConf.DBName), args["postId"])
+    }
 
-    // Renders the template
-    if err != nil {
+    // Drafts are only seen by superusers
+    if err != nil || (!post.Published && !IsSuperuser(c, req)) {
         http.Error(c, "Not found", http.StatusNotFound)
         return
     }
 
     // Method to return post info
-    if req.Method == "GET" {
+    if req.Method == "GET" && args["postSlug"] != "" {
+        // Posts read by slug come with the previous and next ones, so their
+        // version depends on those too
+        previous, next, err := GetAdjacentBlogPosts(dbDefaultConn.DB(systemConf.DBName), post)
+        var categories []Category
+        var related []PostLink
+        if err == nil {
+            categories, err = GetPostCategories(dbDefaultConn.DB(systemConf.DBName), post)
+        }
+        if err == nil {
+            related, err = GetRelatedPosts(dbDefaultConn.DB(systemConf.DBName), post)
+        }
+        if err != nil {
+            http.Error(c, "Server error", http.StatusInternalServerError)
+            return
+        }
+        b, err := json.Marshal(map[string]interface{}{"result":"ok", "post":post, "previous":postLink(previous), "next":postLink(next),
+            "categories":categories, "related":related,
+            "html":renderPublicContent(dbDefaultConn.DB(systemConf.DBName), post.Content)})
+        if err == nil {
+            data = string(b)
+        } else {
+            fmt.Println("error:", err)
+        }
+
+        if checkNotModified(c, req, contentTag(b), time.Time{}) {
+            return
+        }
+
+    } else if req.Method == "GET" {
         // Encoding to JSON
         b, err := json.Marshal(post)
         if err == nil {
@@ -406,43 +492,42 @@ func BlogPostInfoHandler(c http.ResponseWriter, req *http.Request) {
             fmt.Println("error:", err)
         }
 
+        // Client has the current version already
+        if checkNotModified(c, req, documentTag(post.Id, post.LastModified()), post.LastModified()) {
+            return
+        }
+
     // Method to update post object
     } else if req.Method == "POST" {
-        // Gets the current session
-        session, err := GetSession(c, req)
-        if err != nil || session.Values["secret"] != systemConf.AuthSecret {
+        // Checks the current session
+        if !IsSuperuser(c, req) {
             // Return error
             http.Error(c, "Unauthorized", http.StatusUnauthorized)
             return
         }
 
-        body, err := ioutil.ReadAll(req.Body)
+        // Optimistic concurrency, the client must be editing the current version
+        if !checkIfMatch(c, req, documentTag(post.Id, post.LastModified())) {
+            return
+        }
+
+        payload, err := parseContentPayload(req)
         if err == nil {
-            postValues, err := url.ParseQuery(string(body))
-            if err == nil {
-                if len(postValues["Title"]) == 0 {
-                    err = errors.New("Title is required")
-                } else if len(postValues["Content"]) == 0 {
-                    err = errors.New("Content is required")
-                } else if len(postValues["Slug"]) == 0 {
-                    err = errors.New("Slug is required")
-                } else {
-                    post.Title = postValues["Title"][0]
-                    post.Content = postValues["Content"][0]
-                    post.Slug = postValues["Slug"][0]
-                    if len(postValues["Tags"]) > 0 {
-                        tags2 := strings.Split(postValues["Tags"][0], ",")
-                        for iTag := range tags2 {
-                            if strings.Trim(tags2[iTag], " ") != "" {
-                                tags = append(tags, strings.Trim(tags2[iTag], " "))
-                            }
-                        }
-                    }
-                    post.Tags = tags
-
-                    err = UpdateBlogPost(dbDefaultConn.DB(systemConf.DBName), &post)
-                }
+            err = payload.validate(true)
+        }
+        if err == nil {
+            if payload.Tags == nil {
+                tags := make([]string,0)
+                payload.Tags = &tags
             }
+            payload.applyToBlogPost(&post)
+            err = UpdateBlogPost(dbDefaultConn.DB(systemConf.DBName), &post)
+        }
+
+        // Changed by someone else meanwhile
+        if err == ErrModified {
+            http.Error(c, fmt.Sprintf("Precondition failed: %v", err), http.StatusPreconditionFailed)
+            return
         }
 
         // Bad request
@@ -450,6 +535,7 @@ func BlogPostInfoHandler(c http.ResponseWriter, req *http.Request) {
             http.Error(c, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
             return
         }
+        c.Header().Set("ETag", documentTag(post.Id, post.LastModified()))
 
     // Method not allowed
     } else {
@@ -504,19 +590,24 @@ func PageListHandler(c http.ResponseWriter, req *http.Request) {
     c.Header().Add("Content-Type", "text/json")
 
     // Posts from database
-    pagesList, err := ListPages(dbDefaultConn.DB(systemConf.DBName))
+    opts, err := parseListOptions(c, req, pageSortFields, "title", 0)
+    if err != nil {
+        http.Error(c, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
+        return
+    }
+    pagesList, total, err := FindPages(dbDefaultConn.DB(systemConf.DBName), opts)
     if err == nil {
         // Encoding to JSON
         b, err := json.Marshal(pagesList)
         if err == nil {
-            data = "{\"pages\":" + string(b) + "}"
+            data = fmt.Sprintf("{\"pages\":%v, \"total\":%v, \"page\":%v, \"limit\":%v}", string(b), total, opts.Page, opts.Limit)
+            setPaginationHeaders(c, req, opts, total)
         } else {
             fmt.Println("error:", err)
         }
     }
 
-    c.Header().Add("Content-Length", strconv.Itoa(len(data)))
-    io.WriteString(c, data)
+    writeCached(c, req, data, time.Time{})
 }
 
 // Page details
@@ -525,27 +616,47 @@ func PageInfoHandler(c http.ResponseWriter, req *http.Request) {
     c.Header().Add("Content-Type", "text/json")
     var page Page
     var err error
-    tags := make([]string,0)
     data := "{\"result\":\"error\"}"
 
     // Parse arguments
     args := mux.Vars(req)
 
-    // Loading page
+    // Loading page, old slugs are redirected to the current one
     if args["pageSlug"] != "" {
         page, err = GetPageBySlug(dbDefaultConn.DB(systemConf.DBName), args["pageSlug"])
+        if err != nil {
+            if page, err = GetPageByOldSlug(dbDefaultConn.DB(systemConf.DBName), args["pageSlug"]); err == nil && (page.Published || IsSuperuser(c, req)) {
+                redirectPermanently(c, req, "/api/page/by-slug/" + page.Slug + "/")
+                return
+            }
+        }
     } else {
         page, err = GetPage(dbDefaultConn.DB(systemConf.DBName), args["pageId"])
     }
 
-    // Renders the template
-    if err != nil {
+    // Drafts are only seen by superusers
+    if err != nil || (!page.Published && !IsSuperuser(c, req)) {
         http.Error(c, "Not found", http.StatusNotFound)
         return
     }
 
     // Method to return page info
-    if req.Method == "GET" {
+    if req.Method == "GET" && args["pageSlug"] != "" {
+        // Pages read by slug come rendered, and their shortcodes depend on
+        // other content, so their version depends on the output
+        b, err := json.Marshal(map[string]interface{}{"result":"ok", "page":page,
+            "html":renderPublicContent(dbDefaultConn.DB(systemConf.DBName), page.Content)})
+        if err == nil {
+            data = string(b)
+        } else {
+            fmt.Println("error:", err)
+        }
+
+        if checkNotModified(c, req, contentTag(b), time.Time{}) {
+            return
+        }
+
+    } else if req.Method == "GET" {
         // Encoding to JSON
         b, err := json.Marshal(page)
         if err == nil {
@@ -554,43 +665,42 @@ func PageInfoHandler(c http.ResponseWriter, req *http.Request) {
             fmt.Println("error:", err)
         }
 
+        // Client has the current version already
+        if checkNotModified(c, req, documentTag(page.Id, page.LastModified()), page.LastModified()) {
+            return
+        }
+
     // Method to update page object
     } else if req.Method == "POST" {
-        // Gets the current session
-        session, err := GetSession(c, req)
-        if err != nil || session.Values["secret"] != systemConf.AuthSecret {
+        // Checks the current session
+        if !IsSuperuser(c, req) {
             // Return error
             http.Error(c, "Unauthorized", http.StatusUnauthorized)
             return
         }
 
-        body, err := ioutil.ReadAll(req.Body)
+        // Optimistic concurrency, the client must be editing the current version
+        if !checkIfMatch(c, req, documentTag(page.Id, page.LastModified())) {
+            return
+        }
+
+        payload, err := parseContentPayload(req)
         if err == nil {
-            postValues, err := url.ParseQuery(string(body))
-            if err == nil {
-                if len(postValues["Title"]) == 0 {
-                    err = errors.New("Title is required")
-                } else if len(postValues["Content"]) == 0 {
-                    err = errors.New("Content is required")
-                } else if len(postValues["Slug"]) == 0 {
-                    err = errors.New("Slug is required")
-                } else {
-                    page.Title = postValues["Title"][0]
-                    page.Content = postValues["Content"][0]
-                    page.Slug = postValues["Slug"][0]
-                    if len(postValues["Tags"]) > 0 {
-                        tags2 := strings.Split(postValues["Tags"][0], ",")
-                        for iTag := range tags2 {
-                            if strings.Trim(tags2[iTag], " ") != "" {
-                                tags = append(tags, strings.Trim(tags2[iTag], " "))
-                            }
-                        }
-                    }
-                    page.Tags = tags
-
-                    err = UpdatePage(dbDefaultConn.DB(systemConf.DBName), &page)
-                }
+            err = payload.validate(true)
+        }
+        if err == nil {
+            if payload.Tags == nil {
+                tags := make([]string,0)
+                payload.Tags = &tags
             }
+            payload.applyToPage(&page)
+            err = UpdatePage(dbDefaultConn.DB(systemConf.DBName), &page)
+        }
+
+        // Changed by someone else meanwhile
+        if err == ErrModified {
+            http.Error(c, fmt.Sprintf("Precondition failed: %v", err), http.StatusPreconditionFailed)
+            return
         }
 
         // Bad request
@@ -598,6 +708,7 @@ func PageInfoHandler(c http.ResponseWriter, req *http.Request) {
             http.Error(c, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
             return
         }
+        c.Header().Set("ETag", documentTag(page.Id, page.LastModified()))
 
     // Method not allowed
     } else {
@@ -615,6 +726,7 @@ func PageViewHandler(c http.ResponseWriter, req *http.Request) {
     c.Header().Add("Content-Type", "text/html")
     var data string
     var found bool
+    var page Page
 
     // Method not allowed
     if req.Method != "GET" {
@@ -629,7 +741,23 @@ func PageViewHandler(c http.ResponseWriter, req *http.Request) {
     if args["pageSlug"] == "404" {
         found = true
     } else {
-        found = PageExists(dbDefaultConn.DB(systemConf.DBName), args["pageSlug"])
+        var err error
+        page, err = GetPageBySlug(dbDefaultConn.DB(systemConf.DBName), args["pageSlug"])
+
+        // Old slugs are redirected to the current one
+        if err != nil {
+            if page, err = GetPageByOldSlug(dbDefaultConn.DB(systemConf.DBName), args["pageSlug"]); err == nil && (page.Published || IsSuperuser(c, req)) {
+                path := "/" + page.Slug
+                if strings.HasSuffix(req.URL.Path, "/") {
+                    path += "/"
+                }
+                redirectPermanently(c, req, path)
+                return
+            }
+        }
+
+        // Drafts are only seen by superusers
+        found = err == nil && (page.Published || IsSuperuser(c, req))
     }
 
     // Page not found
@@ -638,14 +766,36 @@ func PageViewHandler(c http.ResponseWriter, req *http.Request) {
         return
     }
 
-    // Renders the template
-    data, err := renderTemplate("base.html")
+    // Renders the template, with the page's metadata
+    head := ""
+    var err error
+    if page.Id.Valid() {
+        var meta seoMeta
+        meta, err = pageSeoMeta(dbDefaultConn.DB(systemConf.DBName), req, page)
+        if err == nil {
+            head, err = renderSeoHead(meta)
+        }
+    }
+    if err == nil {
+        data, err = renderTemplate("base.html")
+        data = withHead(data, head)
+    }
 
     if err != nil {
+        log.Println(err)
         http.Error(c, "Server error", http.StatusInternalServerError)
         return
     }
 
+    // The version depends on both the template and the page
+    tag := contentTag([]byte(data))
+    if page.Id.Valid() {
+        tag = contentTag([]byte(data + documentTag(page.Id, page.LastModified())))
+    }
+    if checkNotModified(c, req, tag, page.LastModified()) {
+        return
+    }
+
     c.Header().Add("Content-Length", strconv.Itoa(len(data)))
     io.WriteString(c, data)
 }
@@ -657,7 +807,7 @@ func PageAddHandler(c http.ResponseWriter, req *http.Request) {
     var data string
     var err error
     var page Page
-    tags := make([]string,0)
+    var payload ContentPayload
 
     // Method not allowed
     if req.Method != "POST" {
@@ -665,34 +815,15 @@ func PageAddHandler(c http.ResponseWriter, req *http.Request) {
         return
     }
 
-    // Save the new post
-    body, err := ioutil.ReadAll(req.Body)
+    // Save the new page
+    payload, err = parseContentPayload(req)
     if err == nil {
-        postValues, err := url.ParseQuery(string(body))
-        if err == nil {
-            if len(postValues["Title"]) == 0 {
-                err = errors.New("Title is required")
-            } else if len(postValues["Content"]) == 0 {
-                err = errors.New("Content is required")
-            } else if len(postValues["Slug"]) == 0 {
-                err = errors.New("Slug is required")
-            } else {
-                title := postValues["Title"][0]
-                content := postValues["Content"][0]
-                slug := postValues["Slug"][0]
-                if len(postValues["Tags"]) > 0 {
-                    tags2 := strings.Split(postValues["Tags"][0], ",")
-                    for iTag := range tags2 {
-                        if strings.Trim(tags2[iTag], " ") != "" {
-                            tags = append(tags, strings.Trim(tags2[iTag], " "))
-                        }
-                    }
-                }
-
-                page = Page{Title:title, Content:content, Published:true, Slug:slug, Author:DEFAULT_AUTHOR, Tags:tags}
-                err = InsertNewPage(dbDefaultConn.DB(systemConf.DBName), &page)
-            }
-        }
+        err = payload.validate(true)
+    }
+    if err == nil {
+        page = Page{Published:true, Author:DEFAULT_AUTHOR, Tags:make([]string,0)}
+        payload.applyToPage(&page)
+        err = InsertNewPage(dbDefaultConn.DB(systemConf.DBName), &page)
     }
 
     if err == nil {
@@ -748,63 +879,80 @@ func PhotoListHandler(c http.ResponseWriter, req *http.Request) {
     c.Header().Add("Content-Type", "text/json")
 
     // Posts from database
-    photosList, err := ListPhotos(dbDefaultConn.DB(systemConf.DBName))
+    opts, err := parseListOptions(c, req, photoSortFields, "-pubdate", 0)
+    if err != nil {
+        http.Error(c, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
+        return
+    }
+    photosList, total, err := FindPhotos(dbDefaultConn.DB(systemConf.DBName), opts)
     if err == nil {
         // Encoding to JSON
         b, err := json.Marshal(photosList)
         if err == nil {
-            data = "{\"photos\":" + string(b) + "}"
+            data = fmt.Sprintf("{\"photos\":%v, \"total\":%v, \"page\":%v, \"limit\":%v}", string(b), total, opts.Page, opts.Limit)
+            setPaginationHeaders(c, req, opts, total)
         } else {
             fmt.Println("error:", err)
         }
     }
 
-    c.Header().Add("Content-Length", strconv.Itoa(len(data)))
-    io.WriteString(c, data)
+    writeCached(c, req, data, time.Time{})
 }
 
-func AdminUploadPhotosHandler(c http.ResponseWriter, req *http.Request) {
-    log.Println(req.URL)
-    c.Header().Add("Content-Type", "text/html")
-    var fileName string
-    var filePath string
-    var mimeType string
+// Saves an uploaded file in the photos folder and creates its database entry
+func savePhotoUpload(handler *multipart.FileHeader) (Photo, error) {
     var photo Photo
 
     // Reading uploaded file
-    file, handler, err := req.FormFile("media")
+    file, err := handler.Open()
     if err != nil {
-        http.Error(c, fmt.Sprintf("%v",err), http.StatusInternalServerError)
-        return
+        return photo, err
     }
+    defer file.Close()
     data, err := ioutil.ReadAll(file)
-    if err != nil || data == nil {
-        http.Error(c, fmt.Sprintf("%v",err), http.StatusInternalServerError)
-        return
+    if err != nil {
+        return photo, err
     }
 
     // Generating file name
     newUuid, err := uuid.NewV4()
     if err != nil {
-        http.Error(c, fmt.Sprintf("%v",err), http.StatusInternalServerError)
-        return
+        return photo, err
     }
-    fileName = strings.Replace(newUuid.String(), "-", "", -1) + strings.ToLower(filepath.Ext(handler.Filename))
-    filePath = filepath.Join(systemConf.PhotosRoot, fileName)
-    mimeType = handler.Header["Content-Type"][0]
-    log.Println("xxxxx", filePath, mimeType) // XXX
+    fileName := strings.Replace(newUuid.String(), "-", "", -1) + strings.ToLower(filepath.Ext(handler.Filename))
+    filePath := filepath.Join(systemConf.PhotosRoot, fileName)
+    mimeType := handler.Header.Get("Content-Type")
 
     // Saving file in file system
     err = ioutil.WriteFile(filePath, data, 0777)
     if err != nil {
-        http.Error(c, fmt.Sprintf("%v",err), http.StatusInternalServerError)
-        return
+        return photo, err
     }
 
     // Creating in database
     photo = Photo{Filename:fileName, MimeType:mimeType, Published:true, Author:DEFAULT_AUTHOR}
     err = InsertNewPhoto(dbDefaultConn.DB(systemConf.DBName), &photo)
 
+    return photo, err
+}
+
+func AdminUploadPhotosHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    c.Header().Add("Content-Type", "text/html")
+
+    // Reading uploaded file
+    _, handler, err := req.FormFile("media")
+    if err != nil {
+        http.Error(c, fmt.Sprintf("%v",err), http.StatusInternalServerError)
+        return
+    }
+
+    _, err = savePhotoUpload(handler)
+    if err != nil {
+        http.Error(c, fmt.Sprintf("%v",err), http.StatusInternalServerError)
+        return
+    }
+
     io.WriteString(c, "<script>parent.closePhotosForm()</script>")
 }
 
@@ -839,6 +987,9 @@ func AdminMenuHandler(c http.ResponseWriter, req *http.Request) {
     menuItemsList = append(menuItemsList, MenuItem{Url:"/admin/pages/", Id:"admin-pages", Label:"Pages"})
     menuItemsList = append(menuItemsList, MenuItem{Url:"/admin/blog-posts/", Id:"admin-blog-posts", Label:"Blog Posts"})
     menuItemsList = append(menuItemsList, MenuItem{Url:"/admin/photos/", Id:"admin-photos", Label:"Photos"})
+    menuItemsList = append(menuItemsList, MenuItem{Url:"/admin/categories/", Id:"admin-categories", Label:"Categories"})
+    menuItemsList = append(menuItemsList, MenuItem{Url:"/admin/tags/", Id:"admin-tags", Label:"Tags"})
+    menuItemsList = append(menuItemsList, MenuItem{Url:"/admin/api/", Id:"admin-api", Label:"API"})
     menuItemsList = append(menuItemsList, MenuItem{Url:"/logout/", Id:"admin-logout", Label:"Logout"})
 
     // Encoding to JSON
@@ -854,49 +1005,140 @@ func AdminMenuHandler(c http.ResponseWriter, req *http.Request) {
     io.WriteString(c, data)
 }
 
-func SetUrls() {
-    // URL routes
+// Returns the router with the URL routes
+func NewRouter() *mux.Router {
     r := mux.NewRouter()
 
     r.HandleFunc("/", HomeHandler)
-    r.HandleFunc("/login/", LoginHandler)
-    r.HandleFunc("/logout/", LogoutHandler)
+    r.HandleFunc("/login/", LoginHandler).Methods("POST", "OPTIONS")
+    r.HandleFunc("/logout/", LogoutHandler).Methods("GET", "HEAD", "OPTIONS")
 
     // Admin
     r.HandleFunc("/admin/", AdminHomeHandler)
     r.HandleFunc("/admin/pages/", RequireSuperuser(AdminHomeHandler))
     r.HandleFunc("/admin/blog-posts/", RequireSuperuser(AdminHomeHandler))
     r.HandleFunc("/admin/photos/", RequireSuperuser(AdminHomeHandler))
+    r.HandleFunc("/admin/tags/", RequireSuperuser(AdminHomeHandler))
+    r.HandleFunc("/admin/categories/", RequireSuperuser(AdminHomeHandler))
+    r.HandleFunc("/admin/api/", RequireSuperuser(AdminHomeHandler))
     r.HandleFunc("/admin/upload-photos/", RequireSuperuser(AdminUploadPhotosHandler))
-    r.HandleFunc("/api/admin/menu/", RequireSuperuser(AdminMenuHandler))
+    r.HandleFunc("/api/admin/menu/", RequireSuperuser(AdminMenuHandler)).Methods("GET", "HEAD", "OPTIONS")
+    setWebhookUrls(r)
 
     // General API methods
-    r.HandleFunc("/api/is-superuser/", IsSuperuserHandler)
-    r.HandleFunc("/api/menu/item/", MenuItemsHandler)
+    r.HandleFunc("/api/openapi.json", OpenApiHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/api/graphql", GraphqlHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    r.HandleFunc("/api/is-superuser/", IsSuperuserHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/api/menu/item/", MenuItemsHandler).Methods("GET", "HEAD", "OPTIONS")
 
     // Blog posts
-    r.HandleFunc("/api/blog/post/", BlogPostListHandler)
-    r.HandleFunc("/api/blog/post/add/", RequireSuperuser(BlogPostAddHandler))
-    r.HandleFunc("/api/blog/post/{postId:\\w+}/", BlogPostInfoHandler)
-    r.HandleFunc("/api/blog/post/{postId:\\w+}/delete/", RequireSuperuser(BlogPostDeleteHandler))
+    r.HandleFunc("/api/blog/post/", BlogPostListHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/api/blog/post/add/", RequireSuperuser(BlogPostAddHandler)).Methods("POST", "OPTIONS")
+    r.HandleFunc("/api/blog/post/{postId:\\w+}/", BlogPostInfoHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    r.HandleFunc("/api/blog/post/{postId:\\w+}/delete/", RequireSuperuser(BlogPostDeleteHandler)).Methods("POST", "OPTIONS")
+    r.HandleFunc("/api/blog/post/by-slug/{postSlug:[\\w\\-]+}/", BlogPostInfoHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    setBlogUrls(r)
+    setTagUrls(r)
+    setCategoryUrls(r)
+    setArchiveUrls(r)
+    setFeedUrls(r)
+    setSitemapUrls(r)
+    setSearchUrls(r)
+    setRelatedUrls(r)
+    setShortcodeUrls(r)
 
     // Pages
-    r.HandleFunc("/api/page/", PageListHandler)
-    r.HandleFunc("/api/page/add/", RequireSuperuser(PageAddHandler))
-    r.HandleFunc("/api/page/{pageId:[\\w\\-]+}/", PageInfoHandler)
-    r.HandleFunc("/api/page/{pageId:\\w+}/delete/", RequireSuperuser(PageDeleteHandler))
-    r.HandleFunc("/api/page/by-slug/{pageSlug:[\\w\\-]+}/", PageInfoHandler)
+    r.HandleFunc("/api/page/", PageListHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/api/page/add/", RequireSuperuser(PageAddHandler)).Methods("POST", "OPTIONS")
+    r.HandleFunc("/api/page/{pageId:[\\w\\-]+}/", PageInfoHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    r.HandleFunc("/api/page/{pageId:\\w+}/delete/", RequireSuperuser(PageDeleteHandler)).Methods("POST", "OPTIONS")
+    r.HandleFunc("/api/page/by-slug/{pageSlug:[\\w\\-]+}/", PageInfoHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
     r.HandleFunc("/{pageSlug:[\\w\\-]+}", PageViewHandler)
     r.HandleFunc("/{pageSlug:[\\w\\-]+}/", PageViewHandler) // This is needed to support both, but maybe there's an alternative
 
     // Photos
-    r.HandleFunc("/api/photo/", PhotoListHandler)
-    r.HandleFunc("/api/photo/published/", PhotoListHandler) // TODO: filter to return only published:True
+    r.HandleFunc("/api/photo/", PhotoListHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/api/photo/published/", PhotoListHandler).Methods("GET", "HEAD", "OPTIONS")
+
+    // REST API, version 2
+    setApiV2Urls(r)
+
+    r.MethodNotAllowedHandler = routeMethodNotAllowed(r)
+    return r
+}
+
+// Answers requests with a method the routes of their path don't take, listing
+// the ones they do
+func routeMethodNotAllowed(r *mux.Router) http.Handler {
+    return http.HandlerFunc(func(c http.ResponseWriter, req *http.Request) {
+        allowed := make([]string,0)
+        for _, method := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"} {
+            probe := req.Clone(req.Context())
+            probe.Method = method
+            var match mux.RouteMatch
+            if r.Match(probe, &match) && match.MatchErr == nil {
+                allowed = append(allowed, method)
+            }
+        }
+        methodNotAllowed(c, allowed...)
+    })
+}
+
+func SetUrls() {
+    // URL routes
+    r := NewRouter()
 
     // Hardcoded ones
+    http.HandleFunc(HIGHLIGHT_CSS_URL, HighlightCssHandler)
     http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(systemConf.StaticRoot))))
     http.Handle("/templates/", http.StripPrefix("/templates/", http.FileServer(http.Dir(systemConf.TemplatesRoot))))
-    http.Handle("/", r)
+    // Cross-origin access to the API, preflight requests included
+    http.Handle("/", CorsMiddleware(r))
+}
+
+// Sets the configuration the server runs with
+func Configure(conf Configuration) error {
+    if err := conf.PublicCors.check(); err != nil {
+        return fmt.Errorf("PublicCors: %v", err)
+    } else if err := conf.AdminCors.check(); err != nil {
+        return fmt.Errorf("AdminCors: %v", err)
+    }
+    systemConf = conf
+    return nil
+}
+
+// Connects to the database of the configuration and brings its collections up
+// to date. Returns the session handlers use, to be closed when done.
+func Connect() (*mgo.Session, error) {
+    var err error
+    dbDefaultConn, err = mgo.Dial(systemConf.DBHostname)
+    if err != nil {
+        return nil, err
+    }
+
+    // Optional. Switch the session to a monotonic behavior.
+    dbDefaultConn.SetMode(mgo.Monotonic, true)
+
+    // Indexes for list queries
+    if err = EnsureIndexes(dbDefaultConn.DB(systemConf.DBName)); err != nil {
+        log.Println("Couldn't create indexes:", err)
+    }
+
+    // Tag slugs of documents saved before tags were normalized
+    if err = NormalizeStoredTags(dbDefaultConn.DB(systemConf.DBName)); err != nil {
+        log.Println("Couldn't normalize tags:", err)
+    }
+
+    // Text to search of documents saved before search existed
+    if err = IndexStoredContent(dbDefaultConn.DB(systemConf.DBName)); err != nil {
+        log.Println("Couldn't index content for search:", err)
+    }
+
+    // Summaries of posts saved before they existed
+    if err = SummarizeStoredPosts(dbDefaultConn.DB(systemConf.DBName)); err != nil {
+        log.Println("Couldn't summarize posts:", err)
+    }
+    return dbDefaultConn, nil
 }
 
 // Main routine
@@ -911,20 +1153,22 @@ func ServerMain() {
     }
 
     // Reading configuration file
-    systemConf = loadConfiguration(params.ConfigurationFile)
+    if err = Configure(loadConfiguration(params.ConfigurationFile)); err != nil {
+        log.Fatal(err)
+    }
 
     // Load connections
-    dbDefaultConn, err = mgo.Dial(systemConf.DBHostname)
+    session, err := Connect()
     if err != nil {
         log.Fatal(err)
     }
-    defer dbDefaultConn.Close()
-
-    // Optional. Switch the session to a monotonic behavior.
-    dbDefaultConn.SetMode(mgo.Monotonic, true)
+    defer session.Close()
 
     SetUrls()
 
+    // Sends webhook deliveries in the background
+    go RunWebhookWorker(session, systemConf.DBName)
+
     // Start serving!
     log.Fatal(http.ListenAndServe(HTTP_ADDRESS, nil))
 }
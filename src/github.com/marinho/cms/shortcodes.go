@@ -0,0 +1,264 @@
+package cms
+
+import (
+    "fmt"
+    "log"
+    "html"
+    "regexp"
+    "strconv"
+    "strings"
+    "net/http"
+    "html/template"
+    "encoding/json"
+    "io/ioutil"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+// Renders a shortcode to HTML, from the arguments written in it
+type ShortcodeFunc func(db *mgo.Database, args map[string]string) (string, error)
+
+// A shortcode that couldn't be rendered
+type ShortcodeError struct {
+    Shortcode string // As written in the content
+    Message string
+}
+
+func (err ShortcodeError) Error() string {
+    return err.Shortcode + ": " + err.Message
+}
+
+// Content to preview, in Markdown
+type PreviewPayload struct {
+    Content string
+}
+
+// Shortcodes by name, as in {{< name arg="value" >}}. RegisterShortcode adds
+// more.
+var shortcodes = map[string]ShortcodeFunc{
+    "gallery": galleryShortcode,
+    "photo": photoShortcode,
+    "pages": pagesShortcode,
+    "video": videoShortcode,
+}
+
+var shortcodePattern = regexp.MustCompile(`\{\{<\s*([\w\-]+)((?:\s+[\w\-]+="[^"]*")*)\s*>\}\}`)
+var shortcodeArgPattern = regexp.MustCompile(`([\w\-]+)="([^"]*)"`)
+var markdownCodePattern = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+var shortcodePlaceholderPattern = regexp.MustCompile(`(?:<p>)?<!--shortcode:(\w+):(\d+)-->(?:</p>)?`)
+
+// Adds a shortcode, or replaces the one with the same name. Renders read the
+// shortcodes without locking, so it must only be called before the server
+// starts, as from an init function.
+func RegisterShortcode(name string, fn ShortcodeFunc) {
+    shortcodes[name] = fn
+}
+
+// Renders content in Markdown to HTML, with its shortcodes rendered too.
+// Shortcodes that fail are left out, or shown as errors if showErrors is true,
+// and returned either way.
+func renderContent(db *mgo.Database, content string) (template.HTML, []ShortcodeError) {
+    return renderContentErrors(db, content, false)
+}
+
+// Renders content like renderContent, showing errors in place of the
+// shortcodes that fail if showErrors is true, as previews do
+func renderContentErrors(db *mgo.Database, content string, showErrors bool) (template.HTML, []ShortcodeError) {
+    errors := make([]ShortcodeError,0)
+    rendered := make([]string,0)
+    nonce := bson.NewObjectId().Hex() // Tells placeholders from comments in the content
+
+    // Shortcodes are rendered apart and put back after Markdown, which would
+    // change their HTML. Those in code are left as written.
+    content = replaceOutsideCode(content, func(code string) string {
+        parts := shortcodePattern.FindStringSubmatch(code)
+        args := make(map[string]string)
+        for _, arg := range shortcodeArgPattern.FindAllStringSubmatch(parts[2], -1) {
+            args[arg[1]] = arg[2]
+        }
+
+        var output string
+        var err error
+        if fn, ok := shortcodes[parts[1]]; ok {
+            output, err = fn(db, args)
+        } else {
+            err = fmt.Errorf("Unknown shortcode \"%v\"", parts[1])
+        }
+        if err != nil {
+            failure := ShortcodeError{Shortcode:code, Message:err.Error()}
+            errors = append(errors, failure)
+            output = ""
+            if showErrors {
+                output = "<div class=\"shortcode-error\">" + html.EscapeString(failure.Error()) + "</div>"
+            }
+        }
+
+        rendered = append(rendered, output)
+        return fmt.Sprintf("<!--shortcode:%v:%d-->", nonce, len(rendered) - 1)
+    })
+
+    body := shortcodePlaceholderPattern.ReplaceAllStringFunc(string(renderMarkdown(content)), func(placeholder string) string {
+        // Comments alike written in the content are left as they are
+        parts := shortcodePlaceholderPattern.FindStringSubmatch(placeholder)
+        i, err := strconv.Atoi(parts[2])
+        if parts[1] != nonce || err != nil || i >= len(rendered) {
+            return placeholder
+        }
+        return rendered[i]
+    })
+    return template.HTML(body), errors
+}
+
+// Replaces the shortcodes of content in Markdown, except in code blocks and
+// spans
+func replaceOutsideCode(content string, replace func(string) string) string {
+    var replaced strings.Builder
+    last := 0
+    for _, code := range markdownCodePattern.FindAllStringIndex(content, -1) {
+        replaced.WriteString(shortcodePattern.ReplaceAllStringFunc(content[last:code[0]], replace))
+        replaced.WriteString(content[code[0]:code[1]])
+        last = code[1]
+    }
+    replaced.WriteString(shortcodePattern.ReplaceAllStringFunc(content[last:], replace))
+    return replaced.String()
+}
+
+// Renders content for pages and feeds, logging the shortcodes that fail
+func renderPublicContent(db *mgo.Database, content string) template.HTML {
+    body, errors := renderContent(db, content)
+    for _, err := range errors {
+        log.Println("Shortcode error:", err)
+    }
+    return body
+}
+
+// Returns the content without its shortcodes
+func stripShortcodes(content string) string {
+    return replaceOutsideCode(content, func(string) string { return "" })
+}
+
+/* SHORTCODES */
+
+// Returns the HTML of a photo, linking to its file
+func photoHtml(photo Photo, caption string) string {
+    src := html.EscapeString("/static/photos/" + photo.Filename)
+    img := fmt.Sprintf("<a href=\"%v\"><img src=\"%v\" alt=\"%v\"/></a>", src, src, html.EscapeString(caption))
+    if caption == "" {
+        return img
+    }
+    return "<figure class=\"shortcode-photo\">" + img + "<figcaption>" + html.EscapeString(caption) + "</figcaption></figure>"
+}
+
+// {{< gallery tag="berlin" limit="12" >}}: published photos with a tag, newest
+// first
+func galleryShortcode(db *mgo.Database, args map[string]string) (string, error) {
+    if args["tag"] == "" {
+        return "", fmt.Errorf("tag is required")
+    }
+    photos, err := ListPhotosByTags(db, []string{args["tag"]})
+    if err != nil {
+        return "", err
+    } else if len(photos) == 0 {
+        return "", fmt.Errorf("No published photos tagged \"%v\"", args["tag"])
+    }
+
+    if args["limit"] != "" {
+        limit, err := strconv.Atoi(args["limit"])
+        if err != nil || limit < 1 {
+            return "", fmt.Errorf("limit must be a positive number")
+        }
+        if limit < len(photos) {
+            photos = photos[:limit]
+        }
+    }
+
+    items := make([]string,0)
+    for _, photo := range photos {
+        items = append(items, photoHtml(photo, ""))
+    }
+    return "<div class=\"shortcode-gallery\">" + strings.Join(items, "") + "</div>", nil
+}
+
+// {{< photo id="..." caption="..." >}}: a published photo
+func photoShortcode(db *mgo.Database, args map[string]string) (string, error) {
+    if !bson.IsObjectIdHex(args["id"]) {
+        return "", fmt.Errorf("id must be a photo id")
+    }
+    photo, err := GetPhoto(db, args["id"])
+    if err == mgo.ErrNotFound || (err == nil && !photo.Published) {
+        return "", fmt.Errorf("Photo %v not found", args["id"])
+    } else if err != nil {
+        return "", err
+    }
+    return photoHtml(photo, args["caption"]), nil
+}
+
+// {{< pages tag="projects" >}}: links to the published pages with a tag. Pages
+// have no parents, so the child pages of one are the ones sharing a tag.
+func pagesShortcode(db *mgo.Database, args map[string]string) (string, error) {
+    if args["tag"] == "" {
+        return "", fmt.Errorf("tag is required")
+    }
+    published := true
+    pages, _, err := FindPages(db, ListOptions{Tag:args["tag"], Sort:[]string{"title"}, Published:&published})
+    if err != nil {
+        return "", err
+    } else if len(pages) == 0 {
+        return "", fmt.Errorf("No published pages tagged \"%v\"", args["tag"])
+    }
+
+    items := make([]string,0)
+    for _, page := range pages {
+        items = append(items, fmt.Sprintf("<li><a href=\"/%v/\">%v</a></li>", html.EscapeString(page.Slug), html.EscapeString(page.Title)))
+    }
+    return "<ul class=\"shortcode-pages\">" + strings.Join(items, "") + "</ul>", nil
+}
+
+var videoIdPattern = regexp.MustCompile(`^[\w\-]+$`)
+
+// {{< video youtube="..." >}} or {{< video vimeo="..." >}}: an embedded video
+func videoShortcode(db *mgo.Database, args map[string]string) (string, error) {
+    var src string
+    if id := args["youtube"]; id != "" && videoIdPattern.MatchString(id) {
+        src = "https://www.youtube-nocookie.com/embed/" + id
+    } else if id := args["vimeo"]; id != "" && videoIdPattern.MatchString(id) {
+        src = "https://player.vimeo.com/video/" + id
+    } else {
+        return "", fmt.Errorf("youtube or vimeo must be a video id")
+    }
+    return "<div class=\"shortcode-video\"><iframe src=\"" + src + "\" frameborder=\"0\" allowfullscreen></iframe></div>", nil
+}
+
+/* HANDLERS */
+
+// Renders content sent by the admin, with the errors of its shortcodes both
+// in place and listed
+func PreviewHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if !checkSuperuser(c, req) {
+        return
+    }
+    if req.Method != "POST" {
+        methodNotAllowed(c, "POST")
+        return
+    }
+
+    var payload PreviewPayload
+    body, err := ioutil.ReadAll(req.Body)
+    if err == nil {
+        err = json.Unmarshal(body, &payload)
+    }
+    if err != nil {
+        writeJSONError(c, http.StatusBadRequest, "Invalid JSON")
+        return
+    }
+
+    rendered, errors := renderContentErrors(dbDefaultConn.DB(systemConf.DBName), payload.Content, true)
+    writeJSON(c, http.StatusOK, map[string]interface{}{"html":rendered, "errors":errors})
+}
+
+// Registers the preview route
+func setShortcodeUrls(r *mux.Router) {
+    r.HandleFunc("/api/admin/preview/", PreviewHandler).Methods("POST", "OPTIONS")
+}
@@ -0,0 +1,279 @@
+package cms
+
+import (
+    "fmt"
+    "log"
+    "sync"
+    "time"
+    "strconv"
+    "net/http"
+    "encoding/xml"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+// Most addresses in a sitemap, by the protocol. Larger sites get an index.
+const SITEMAP_URLS = 50000
+
+// Robots.txt served when the configuration has none
+const DEFAULT_ROBOTS = "User-agent: *\nDisallow: /admin/\nDisallow: /api/\n"
+
+type sitemapUrlSet struct {
+    XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+    Urls []sitemapUrl `xml:"url"`
+}
+
+type sitemapUrl struct {
+    Loc string `xml:"loc"`
+    LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+    XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+    Sitemaps []sitemapUrl `xml:"sitemap"`
+}
+
+// Addresses of the sitemaps, relative to the site, until content changes. The
+// site address is added as they're written, so every host shares them.
+var sitemapCache = struct {
+    sync.Mutex
+    urls []sitemapUrl // Nil until built
+    version int // Changed with the content, so older builds aren't kept
+}{}
+
+// Clears what was built from the content. Called by the functions storing
+// posts, pages, photos and categories, once a change is saved.
+func contentChanged() {
+    sitemapCache.Lock()
+    sitemapCache.urls = nil
+    sitemapCache.version++
+    sitemapCache.Unlock()
+    clearRelatedPosts()
+}
+
+// Keeps the latest of the times for the key
+func setLatest(times map[string]time.Time, key string, t time.Time) {
+    if t.After(times[key]) {
+        times[key] = t
+    }
+}
+
+// Returns the addresses of the published content, relative to the site: home,
+// pages, posts, and the tag, category and archive views with posts. Content
+// marked as not to be indexed is left out.
+func sitemapUrls(db *mgo.Database) ([]sitemapUrl, error) {
+    urls := make([]sitemapUrl,0)
+    add := func(path string, modified time.Time) {
+        url := sitemapUrl{Loc:path}
+        if !modified.IsZero() {
+            url.LastMod = modified.UTC().Format(time.RFC3339)
+        }
+        urls = append(urls, url)
+    }
+
+    tagTimes := make(map[string]time.Time)
+    categoryTimes := make(map[string]time.Time)
+    archiveTimes := make(map[string]time.Time)
+    var latest time.Time
+
+    postUrls := make([]sitemapUrl,0)
+    var post BlogPost
+    notIndexed := bson.M{"$ne":true}
+    iter := db.C(BLOG_POST_COLL_NAME).Find(bson.M{"published":true, "seo.noindex":notIndexed}).Sort("-pubdate").
+        Select(bson.M{"slug":1, "pubdate":1, "modified":1, "tagslugs":1, "categories":1}).Iter()
+    for iter.Next(&post) {
+        modified := post.LastModified()
+        if modified.After(latest) {
+            latest = modified
+        }
+        postUrls = append(postUrls, sitemapUrl{Loc:post.Url(), LastMod:modified.UTC().Format(time.RFC3339)})
+        for _, slug := range post.TagSlugs {
+            setLatest(tagTimes, slug, modified)
+        }
+        for _, id := range post.Categories {
+            setLatest(categoryTimes, id.Hex(), modified)
+        }
+        date := post.PubDate.UTC()
+        setLatest(archiveTimes, ArchiveYear{Year:date.Year()}.Url(), modified)
+        setLatest(archiveTimes, ArchiveMonth{Year:date.Year(), Month:int(date.Month())}.Url(), modified)
+    }
+    if err := iter.Close(); err != nil {
+        return nil, err
+    }
+
+    add("/", latest)
+
+    var page Page
+    iter = db.C(PAGE_COLL_NAME).Find(bson.M{"published":true, "seo.noindex":notIndexed}).Sort("slug").
+        Select(bson.M{"slug":1, "pubdate":1, "modified":1, "tagslugs":1}).Iter()
+    for iter.Next(&page) {
+        add("/" + page.Slug, page.LastModified())
+        for _, slug := range page.TagSlugs {
+            setLatest(tagTimes, slug, page.LastModified())
+        }
+    }
+    if err := iter.Close(); err != nil {
+        return nil, err
+    }
+
+    urls = append(urls, postUrls...)
+
+    tags, err := ListTags(db, false)
+    if err != nil {
+        return nil, err
+    }
+    for _, tag := range tags {
+        if tag.Posts > 0 || tag.Pages > 0 {
+            add("/tag/" + tag.Slug + "/", tagTimes[tag.Slug])
+        }
+    }
+
+    // Categories show the posts of their subcategories too
+    categories, err := ListCategories(db)
+    if err != nil {
+        return nil, err
+    }
+    for _, category := range categories {
+        var modified time.Time
+        for _, id := range categoryDescendants(categories, category.Id) {
+            if categoryTimes[id.Hex()].After(modified) {
+                modified = categoryTimes[id.Hex()]
+            }
+        }
+        if !modified.IsZero() {
+            add(category.Url(), modified)
+        }
+    }
+
+    years, err := GetBlogArchive(db)
+    if err != nil {
+        return nil, err
+    }
+    for _, year := range years {
+        add(year.Url(), archiveTimes[year.Url()])
+        for _, month := range year.Months {
+            add(month.Url(), archiveTimes[month.Url()])
+        }
+    }
+    return urls, nil
+}
+
+// Builds the sitemaps of the site at the base address from the relative
+// addresses. When there are more addresses than a sitemap takes, the first one
+// is an index of the others.
+func buildSitemaps(relative []sitemapUrl, base string) ([]string, error) {
+    urls := make([]sitemapUrl, len(relative))
+    for i, url := range relative {
+        urls[i] = sitemapUrl{Loc:base + url.Loc, LastMod:url.LastMod}
+    }
+
+    encode := func(doc interface{}) (string, error) {
+        b, err := xml.MarshalIndent(doc, "", "  ")
+        return xml.Header + string(b), err
+    }
+
+    if len(urls) <= SITEMAP_URLS {
+        doc, err := encode(sitemapUrlSet{Urls:urls})
+        return []string{doc}, err
+    }
+
+    index := sitemapIndex{}
+    sitemaps := []string{""}
+    for start := 0; start < len(urls); start += SITEMAP_URLS {
+        end := start + SITEMAP_URLS
+        if end > len(urls) {
+            end = len(urls)
+        }
+        doc, err := encode(sitemapUrlSet{Urls:urls[start:end]})
+        if err != nil {
+            return nil, err
+        }
+        sitemaps = append(sitemaps, doc)
+        index.Sitemaps = append(index.Sitemaps, sitemapUrl{Loc:fmt.Sprintf("%v/sitemap-%d.xml", base, len(sitemaps)-1)})
+    }
+    var err error
+    sitemaps[0], err = encode(index)
+    return sitemaps, err
+}
+
+// Returns the cached addresses of the sitemaps, loading them if content
+// changed since
+func getSitemapUrls(db *mgo.Database) ([]sitemapUrl, error) {
+    sitemapCache.Lock()
+    urls, version := sitemapCache.urls, sitemapCache.version
+    sitemapCache.Unlock()
+    if urls != nil {
+        return urls, nil
+    }
+
+    // Loaded without the lock, so other requests don't wait on the queries
+    urls, err := sitemapUrls(db)
+    if err == nil {
+        sitemapCache.Lock()
+        if sitemapCache.version == version {
+            sitemapCache.urls = urls
+        }
+        sitemapCache.Unlock()
+    }
+    return urls, err
+}
+
+/* HANDLERS */
+
+// The sitemap, or the index of the sitemaps, of published content
+func SitemapHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        http.Error(c, "Invalid method.", http.StatusMethodNotAllowed)
+        return
+    }
+
+    urls, err := getSitemapUrls(dbDefaultConn.DB(systemConf.DBName))
+    var sitemaps []string
+    if err == nil {
+        sitemaps, err = buildSitemaps(urls, siteUrl(req))
+    }
+    if err != nil {
+        log.Println(err)
+        http.Error(c, "Server error", http.StatusInternalServerError)
+        return
+    }
+
+    number := 0
+    if value := mux.Vars(req)["number"]; value != "" {
+        number, _ = strconv.Atoi(value)
+        if number < 1 || number >= len(sitemaps) {
+            http.Error(c, "Not found", http.StatusNotFound)
+            return
+        }
+    }
+    c.Header().Set("Content-Type", "application/xml; charset=utf-8")
+    writeCached(c, req, sitemaps[number], time.Time{})
+}
+
+// The robots.txt of the configuration, or the default one, pointing to the
+// sitemap
+func RobotsHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        http.Error(c, "Invalid method.", http.StatusMethodNotAllowed)
+        return
+    }
+
+    robots := systemConf.RobotsTxt
+    if robots == "" {
+        robots = DEFAULT_ROBOTS
+    }
+    robots += "\nSitemap: " + siteUrl(req) + "/sitemap.xml\n"
+
+    c.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    writeCached(c, req, robots, time.Time{})
+}
+
+// Registers the sitemap and robots.txt routes
+func setSitemapUrls(r *mux.Router) {
+    r.HandleFunc("/sitemap.xml", SitemapHandler)
+    r.HandleFunc("/sitemap-{number:[0-9]+}.xml", SitemapHandler)
+    r.HandleFunc("/robots.txt", RobotsHandler)
+}
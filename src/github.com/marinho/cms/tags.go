@@ -0,0 +1,393 @@
+package cms
+
+import (
+    "fmt"
+    "log"
+    "sort"
+    "time"
+    "net/http"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+// A tag and how many documents have it
+type Tag struct {
+    Slug string
+    Name string // As first written
+    Posts int
+    Pages int
+    Photos int
+    Total int
+}
+
+// Name and slug of a tag, to link to its page
+type TagLink struct {
+    Name string
+    Slug string
+}
+
+// Documents changed by renaming or merging tags
+type TagChangeResult struct {
+    Tag Tag
+    Posts int
+    Pages int
+    Photos int
+}
+
+// Returns the links to the tags of a document
+func tagLinks(names []string, slugs []string) []TagLink {
+    links := make([]TagLink,0)
+    for i, name := range names {
+        if i < len(slugs) {
+            links = append(links, TagLink{Name:name, Slug:slugs[i]})
+        }
+    }
+    return links
+}
+
+// Returns the tags of posts, pages and photos with their counts, sorted by
+// slug. Only published documents are counted, unless all is true.
+func ListTags(db *mgo.Database, all bool) ([]Tag, error) {
+    tags := make(map[string]*Tag)
+    filter := bson.M{"tagslugs":bson.M{"$ne":nil}}
+    if !all {
+        filter["published"] = true
+    }
+
+    for _, collName := range []string{BLOG_POST_COLL_NAME, PAGE_COLL_NAME, PHOTO_COLL_NAME} {
+        var doc struct {
+            Tags []string
+            TagSlugs []string
+        }
+        iter := db.C(collName).Find(filter).Select(bson.M{"tags":1, "tagslugs":1}).Iter()
+        for iter.Next(&doc) {
+            for _, link := range tagLinks(doc.Tags, doc.TagSlugs) {
+                tag, ok := tags[link.Slug]
+                if !ok {
+                    tag = &Tag{Slug:link.Slug, Name:link.Name}
+                    tags[link.Slug] = tag
+                }
+                switch collName {
+                case BLOG_POST_COLL_NAME:
+                    tag.Posts++
+                case PAGE_COLL_NAME:
+                    tag.Pages++
+                case PHOTO_COLL_NAME:
+                    tag.Photos++
+                }
+                tag.Total++
+            }
+        }
+        if err := iter.Close(); err != nil {
+            return nil, err
+        }
+    }
+
+    list := make([]Tag,0)
+    for _, tag := range tags {
+        list = append(list, *tag)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Slug < list[j].Slug })
+    return list, nil
+}
+
+// Returns a tag with its counts, or mgo.ErrNotFound if no document has it.
+// Only the documents with the tag are read.
+func GetTag(db *mgo.Database, slug string, all bool) (Tag, error) {
+    tag := Tag{Slug:slug}
+    filter := bson.M{"tagslugs":slug}
+    if !all {
+        filter["published"] = true
+    }
+
+    for _, collName := range []string{BLOG_POST_COLL_NAME, PAGE_COLL_NAME, PHOTO_COLL_NAME} {
+        query := db.C(collName).Find(filter)
+        count, err := query.Count()
+        if err != nil {
+            return Tag{}, err
+        }
+        switch collName {
+        case BLOG_POST_COLL_NAME:
+            tag.Posts = count
+        case PAGE_COLL_NAME:
+            tag.Pages = count
+        case PHOTO_COLL_NAME:
+            tag.Photos = count
+        }
+        tag.Total += count
+
+        // Named as written in the first document, as ListTags does
+        if tag.Name == "" && count > 0 {
+            var doc struct {
+                Tags []string
+                TagSlugs []string
+            }
+            if err = query.Select(bson.M{"tags":1, "tagslugs":1}).One(&doc); err != nil {
+                return Tag{}, err
+            }
+            for _, link := range tagLinks(doc.Tags, doc.TagSlugs) {
+                if link.Slug == slug {
+                    tag.Name = link.Name
+                    break
+                }
+            }
+        }
+    }
+
+    if tag.Total == 0 {
+        return Tag{}, mgo.ErrNotFound
+    }
+    return tag, nil
+}
+
+// Returns the tags with the ones whose slug is in sources replaced by target
+func replaceTags(tags []string, sources []string, target string) []string {
+    replaced := make([]string,0)
+    for _, tag := range tags {
+        if containsString(sources, Slugify(tag)) {
+            tag = target
+        }
+        replaced = append(replaced, tag)
+    }
+    names, _ := normalizeTags(replaced)
+    return names
+}
+
+// Replaces the tags with the source slugs by the target one, in every post,
+// page and photo. Documents are saved through their update functions, so their
+// modification times change and webhooks are notified. Renaming a tag is a
+// merge of a single source, and renaming it to an existing tag merges both.
+func MergeTags(db *mgo.Database, sources []string, target string) (TagChangeResult, error) {
+    result := TagChangeResult{}
+    filter := bson.M{"tagslugs":bson.M{"$in":sources}}
+
+    posts := make([]BlogPost,0)
+    if err := db.C(BLOG_POST_COLL_NAME).Find(filter).All(&posts); err != nil {
+        return result, err
+    }
+    for i := range posts {
+        posts[i].Tags = replaceTags(posts[i].Tags, sources, target)
+        if err := UpdateBlogPost(db, &posts[i]); err != nil {
+            return result, err
+        }
+        result.Posts++
+    }
+
+    pages := make([]Page,0)
+    if err := db.C(PAGE_COLL_NAME).Find(filter).All(&pages); err != nil {
+        return result, err
+    }
+    for i := range pages {
+        pages[i].Tags = replaceTags(pages[i].Tags, sources, target)
+        if err := UpdatePage(db, &pages[i]); err != nil {
+            return result, err
+        }
+        result.Pages++
+    }
+
+    photos := make([]Photo,0)
+    if err := db.C(PHOTO_COLL_NAME).Find(filter).All(&photos); err != nil {
+        return result, err
+    }
+    for i := range photos {
+        photos[i].Tags = replaceTags(photos[i].Tags, sources, target)
+        if err := UpdatePhoto(db, &photos[i]); err != nil {
+            return result, err
+        }
+        result.Photos++
+    }
+
+    tag, err := GetTag(db, Slugify(target), true)
+    if err == mgo.ErrNotFound {
+        // No document had the tags
+        tag, err = Tag{Slug:Slugify(target), Name:target}, nil
+    }
+    result.Tag = tag
+    return result, err
+}
+
+// Fills the tag slugs of documents saved before tags were normalized
+func NormalizeStoredTags(db *mgo.Database) error {
+    for _, collName := range []string{BLOG_POST_COLL_NAME, PAGE_COLL_NAME, PHOTO_COLL_NAME} {
+        coll := db.C(collName)
+        var doc struct {
+            Id bson.ObjectId `bson:"_id"`
+            Tags []string
+        }
+        iter := coll.Find(bson.M{"tagslugs":bson.M{"$exists":false}}).Select(bson.M{"tags":1}).Iter()
+        for iter.Next(&doc) {
+            tags, slugs := normalizeTags(doc.Tags)
+            if err := coll.UpdateId(doc.Id, bson.M{"$set":bson.M{"tags":tags, "tagslugs":slugs}}); err != nil {
+                iter.Close()
+                return err
+            }
+        }
+        if err := iter.Close(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+/* HANDLERS */
+
+// Tags with their counts. Superusers may count unpublished documents too, with
+// published=all.
+func TagListHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        methodNotAllowed(c, "GET", "HEAD")
+        return
+    }
+    all := req.URL.Query().Get("published") == "all" && IsSuperuser(c, req)
+
+    tags, err := ListTags(dbDefaultConn.DB(systemConf.DBName), all)
+    if err != nil {
+        writeJSONError(c, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSONCached(c, req, map[string]interface{}{"tags":tags, "total":len(tags)}, "", time.Time{})
+}
+
+// A tag with its counts, by slug
+func TagInfoHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if req.Method != "GET" && req.Method != "HEAD" {
+        methodNotAllowed(c, "GET", "HEAD")
+        return
+    }
+    all := req.URL.Query().Get("published") == "all" && IsSuperuser(c, req)
+
+    tag, err := GetTag(dbDefaultConn.DB(systemConf.DBName), mux.Vars(req)["tagSlug"], all)
+    if err == mgo.ErrNotFound {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    } else if err != nil {
+        writeJSONError(c, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSONCached(c, req, map[string]interface{}{"result":"ok", "tag":tag}, "", time.Time{})
+}
+
+// Renames a tag in every document
+func TagRenameHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if !checkSuperuser(c, req) {
+        return
+    }
+    if req.Method != "POST" {
+        methodNotAllowed(c, "POST")
+        return
+    }
+
+    payload, err := parseTagChangePayload(req)
+    if err == nil {
+        payload.Tags = []string{mux.Vars(req)["tagSlug"]}
+        err = payload.validate()
+    }
+    if err != nil {
+        writeJSONError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+    writeTagChange(c, payload)
+}
+
+// Merges several tags into one, in every document
+func TagMergeHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if !checkSuperuser(c, req) {
+        return
+    }
+    if req.Method != "POST" {
+        methodNotAllowed(c, "POST")
+        return
+    }
+
+    payload, err := parseTagChangePayload(req)
+    if err == nil {
+        err = payload.validate()
+    }
+    if err != nil {
+        writeJSONError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+    writeTagChange(c, payload)
+}
+
+// Applies a rename or merge and writes its result
+func writeTagChange(c http.ResponseWriter, payload TagChangePayload) {
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    sources := make([]string,0)
+    for _, tag := range payload.Tags {
+        sources = append(sources, Slugify(tag))
+    }
+    if len(sources) == 1 {
+        if _, err := GetTag(db, sources[0], true); err == mgo.ErrNotFound {
+            writeJSONError(c, http.StatusNotFound, "Not found")
+            return
+        }
+    }
+
+    result, err := MergeTags(db, sources, payload.Name)
+    if err != nil {
+        writeUpdateError(c, err)
+        return
+    }
+    writeJSON(c, http.StatusOK, result)
+}
+
+// Archive page of a tag, with its published posts and pages
+func TagViewHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.URL)
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    if req.Method != "GET" && req.Method != "HEAD" {
+        http.Error(c, "Invalid method.", http.StatusMethodNotAllowed)
+        return
+    }
+
+    slug := mux.Vars(req)["tagSlug"]
+    tag, err := GetTag(db, slug, false)
+    if err == mgo.ErrNotFound {
+        http.Error(c, fmt.Sprintf("Tag \"%v\" not found", slug), http.StatusNotFound)
+        return
+    }
+
+    published := true
+    var posts []BlogPost
+    var pages []Page
+    if err == nil {
+        posts, _, err = FindBlogPosts(db, ListOptions{Tag:slug, Sort:[]string{"-pubdate"}, Published:&published})
+    }
+    if err == nil {
+        pages, _, err = FindPages(db, ListOptions{Tag:slug, Sort:[]string{"title"}, Published:&published})
+    }
+    if err == nil {
+        var data string
+        data, err = renderServerTemplate("tag.html", map[string]interface{}{
+            "Tag": tag,
+            "Posts": posts,
+            "Pages": pages,
+        })
+        if err == nil {
+            c.Header().Set("Content-Type", "text/html; charset=utf-8")
+            writeCached(c, req, data, time.Time{})
+            return
+        }
+    }
+
+    log.Println(err)
+    http.Error(c, "Server error", http.StatusInternalServerError)
+}
+
+// Registers the tag routes
+func setTagUrls(r *mux.Router) {
+    r.HandleFunc("/api/tag/", TagListHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/api/tag/{tagSlug:[\\w\\-]+}/", TagInfoHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/api/admin/tags/merge/", TagMergeHandler).Methods("POST", "OPTIONS")
+    r.HandleFunc("/api/admin/tags/{tagSlug:[\\w\\-]+}/rename/", TagRenameHandler).Methods("POST", "OPTIONS")
+    r.HandleFunc("/tag/{tagSlug:[\\w\\-]+}", TagViewHandler)
+    r.HandleFunc("/tag/{tagSlug:[\\w\\-]+}/", TagViewHandler)
+}
@@ -0,0 +1,453 @@
+package cms
+
+import (
+    "log"
+    "time"
+    "bytes"
+    "errors"
+    "net/http"
+    "io/ioutil"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "github.com/gorilla/mux"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+)
+
+const WEBHOOK_COLL_NAME = "webhooks"
+type Webhook struct {
+    Id bson.ObjectId `bson:"_id,omitempty"`
+    Url string
+    Events []string // Event names, or "*" for all of them
+    Secret string // Key of the payload signatures, none if empty
+    Active bool
+    Created time.Time
+}
+
+// Deliveries are both the queue the worker sends from and the log of each hook
+const WEBHOOK_DELIVERY_COLL_NAME = "webhook_deliveries"
+type WebhookDelivery struct {
+    Id bson.ObjectId `bson:"_id,omitempty"`
+    HookId bson.ObjectId
+    Event string
+    Payload string // JSON body, signed as is
+    Status string // pending, sending, delivered or failed
+    Attempts int
+    NextAttempt time.Time
+    StatusCode int // Of the last attempt
+    Error string // Of the last attempt
+    Created time.Time
+    Finished time.Time
+}
+
+const (
+    DELIVERY_PENDING = "pending"
+    DELIVERY_SENDING = "sending"
+    DELIVERY_DELIVERED = "delivered"
+    DELIVERY_FAILED = "failed"
+)
+
+const MAX_WEBHOOK_ATTEMPTS = 8
+const WEBHOOK_RETRY_DELAY = 30 * time.Second // Doubled after each failed attempt
+const WEBHOOK_POLL_INTERVAL = 5 * time.Second
+const WEBHOOK_TIMEOUT = 10 * time.Second
+
+// Events hooks can subscribe to
+var webhookEvents = []string{
+    "post.created", "post.updated", "post.published", "post.deleted",
+    "page.created", "page.updated", "page.deleted",
+    "photo.created", "photo.updated", "photo.deleted",
+}
+
+// Fields the delivery log can be sorted by
+var webhookDeliverySortFields = map[string]string{"created":"created", "status":"status", "event":"event"}
+
+// Body of the requests sent to hooks
+type webhookMessage struct {
+    Event string `json:"event"`
+    Timestamp time.Time `json:"timestamp"`
+    Data interface{} `json:"data"`
+}
+
+var webhookClient = &http.Client{Timeout:WEBHOOK_TIMEOUT}
+
+// Returns true for the names hooks can subscribe to
+func isWebhookEvent(event string) bool {
+    if event == "*" {
+        return true
+    }
+    for _, name := range webhookEvents {
+        if name == event {
+            return true
+        }
+    }
+    return false
+}
+
+/* DATABASE */
+
+// Returns all webhooks, oldest first
+func ListWebhooks(db *mgo.Database) ([]Webhook, error) {
+    hooks := make([]Webhook,0)
+    err := db.C(WEBHOOK_COLL_NAME).Find(nil).Sort("created").All(&hooks)
+    return hooks, err
+}
+
+// Loads and return a webhook from database
+func GetWebhook(db *mgo.Database, hookId string) (Webhook,error) {
+    hook := Webhook{}
+    err := db.C(WEBHOOK_COLL_NAME).Find(bson.M{"_id":bson.ObjectIdHex(hookId)}).One(&hook)
+    return hook, err
+}
+
+// Inserts a new webhook
+func InsertNewWebhook(db *mgo.Database, hook *Webhook) error {
+    hook.Id = bson.NewObjectId()
+    hook.Created = time.Now()
+    return db.C(WEBHOOK_COLL_NAME).Insert(hook)
+}
+
+// Updates an existing webhook
+func UpdateWebhook(db *mgo.Database, hook *Webhook) error {
+    return db.C(WEBHOOK_COLL_NAME).Update(bson.M{"_id":hook.Id}, hook)
+}
+
+// Removes a webhook and its delivery log
+func DeleteWebhook(db *mgo.Database, hookId string) error {
+    err := db.C(WEBHOOK_COLL_NAME).Remove(bson.M{"_id":bson.ObjectIdHex(hookId)})
+    if err != nil {
+        return err
+    }
+    _, err = db.C(WEBHOOK_DELIVERY_COLL_NAME).RemoveAll(bson.M{"hookid":bson.ObjectIdHex(hookId)})
+    return err
+}
+
+// Returns a page of the deliveries of a hook and their total. Only the page,
+// limit and sort options apply.
+func FindWebhookDeliveries(db *mgo.Database, hookId string, opts ListOptions) ([]WebhookDelivery, int, error) {
+    deliveries := make([]WebhookDelivery,0)
+    query := db.C(WEBHOOK_DELIVERY_COLL_NAME).Find(bson.M{"hookid":bson.ObjectIdHex(hookId)})
+
+    total, err := query.Count()
+    if err != nil {
+        return deliveries, 0, err
+    }
+
+    err = query.Sort(opts.Sort...).Skip((opts.Page - 1) * opts.Limit).Limit(opts.Limit).All(&deliveries)
+    return deliveries, total, err
+}
+
+// Queues a delivery of the event to one hook
+func queueWebhookDelivery(db *mgo.Database, hook Webhook, event string, payload []byte) (WebhookDelivery, error) {
+    now := time.Now()
+    delivery := WebhookDelivery{
+        Id: bson.NewObjectId(),
+        HookId: hook.Id,
+        Event: event,
+        Payload: string(payload),
+        Status: DELIVERY_PENDING,
+        NextAttempt: now,
+        Created: now,
+    }
+    err := db.C(WEBHOOK_DELIVERY_COLL_NAME).Insert(&delivery)
+    return delivery, err
+}
+
+// Queues the event for every active hook subscribed to it. Failures are only
+// logged, so they never undo the change that triggered the event.
+func triggerWebhooks(db *mgo.Database, event string, data interface{}) {
+    var hooks []Webhook
+    selector := bson.M{"active":true, "events":bson.M{"$in":[]string{event, "*"}}}
+    if err := db.C(WEBHOOK_COLL_NAME).Find(selector).All(&hooks); err != nil {
+        log.Println("Couldn't load webhooks:", err)
+        return
+    }
+    if len(hooks) == 0 {
+        return
+    }
+
+    payload, err := json.Marshal(webhookMessage{Event:event, Timestamp:time.Now().UTC(), Data:data})
+    if err != nil {
+        log.Println("Couldn't encode webhook payload:", err)
+        return
+    }
+
+    for _, hook := range hooks {
+        if _, err = queueWebhookDelivery(db, hook, event, payload); err != nil {
+            log.Println("Couldn't queue webhook delivery:", err)
+        }
+    }
+}
+
+/* DELIVERY */
+
+// Returns the signature of a payload, sent in the X-CMS-Signature header
+func webhookSignature(secret string, payload []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(payload)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Delay before the next attempt, after the given number of failed ones
+func webhookRetryDelay(attempts int) time.Duration {
+    return WEBHOOK_RETRY_DELAY << uint(attempts - 1)
+}
+
+// Sends a delivery to its hook, returning the response status code
+func sendWebhookDelivery(hook Webhook, delivery WebhookDelivery) (int, error) {
+    payload := []byte(delivery.Payload)
+    req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(payload))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "marinho-cms-webhooks")
+    req.Header.Set("X-CMS-Event", delivery.Event)
+    req.Header.Set("X-CMS-Delivery", delivery.Id.Hex())
+    if hook.Secret != "" {
+        req.Header.Set("X-CMS-Signature", webhookSignature(hook.Secret, payload))
+    }
+
+    resp, err := webhookClient.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    ioutil.ReadAll(resp.Body)
+
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return resp.StatusCode, errors.New("Unexpected response: " + resp.Status)
+    }
+    return resp.StatusCode, nil
+}
+
+// Claims and sends the deliveries that are due, recording the outcome of each
+// attempt. Failed ones are retried later, until MAX_WEBHOOK_ATTEMPTS.
+func processWebhookDeliveries(db *mgo.Database) {
+    coll := db.C(WEBHOOK_DELIVERY_COLL_NAME)
+
+    for {
+        var delivery WebhookDelivery
+        selector := bson.M{"status":DELIVERY_PENDING, "nextattempt":bson.M{"$lte":time.Now()}}
+        change := mgo.Change{Update:bson.M{"$set":bson.M{"status":DELIVERY_SENDING}}, ReturnNew:true}
+        if _, err := coll.Find(selector).Sort("nextattempt").Apply(change, &delivery); err != nil {
+            if err != mgo.ErrNotFound {
+                log.Println("Couldn't claim webhook delivery:", err)
+            }
+            return
+        }
+
+        update := bson.M{"attempts":delivery.Attempts + 1}
+        hook, err := GetWebhook(db, delivery.HookId.Hex())
+        if err != nil {
+            update["status"] = DELIVERY_FAILED
+            update["error"] = "Webhook not found"
+            update["finished"] = time.Now()
+        } else {
+            statusCode, err := sendWebhookDelivery(hook, delivery)
+            update["statuscode"] = statusCode
+            if err == nil {
+                update["status"] = DELIVERY_DELIVERED
+                update["error"] = ""
+                update["finished"] = time.Now()
+            } else if delivery.Attempts + 1 >= MAX_WEBHOOK_ATTEMPTS {
+                update["status"] = DELIVERY_FAILED
+                update["error"] = err.Error()
+                update["finished"] = time.Now()
+            } else {
+                update["status"] = DELIVERY_PENDING
+                update["error"] = err.Error()
+                update["nextattempt"] = time.Now().Add(webhookRetryDelay(delivery.Attempts + 1))
+            }
+        }
+
+        if err = coll.UpdateId(delivery.Id, bson.M{"$set":update}); err != nil {
+            log.Println("Couldn't save webhook delivery:", err)
+        }
+    }
+}
+
+// Sends queued deliveries until the process ends. Deliveries left sending by
+// a previous process are queued again first.
+func RunWebhookWorker(session *mgo.Session, dbName string) {
+    session = session.Copy()
+    defer session.Close()
+    db := session.DB(dbName)
+
+    _, err := db.C(WEBHOOK_DELIVERY_COLL_NAME).UpdateAll(bson.M{"status":DELIVERY_SENDING}, bson.M{"$set":bson.M{"status":DELIVERY_PENDING}})
+    if err != nil {
+        log.Println("Couldn't requeue webhook deliveries:", err)
+    }
+
+    for {
+        processWebhookDeliveries(db)
+        time.Sleep(WEBHOOK_POLL_INTERVAL)
+        session.Refresh()
+    }
+}
+
+/* HANDLERS */
+
+// Webhooks collection: GET lists, POST creates
+func WebhookCollectionHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if !checkSuperuser(c, req) {
+        return
+    }
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    switch req.Method {
+    case "GET", "HEAD":
+        hooks, err := ListWebhooks(db)
+        if err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        writeJSON(c, http.StatusOK, map[string]interface{}{"webhooks":hooks, "events":webhookEvents})
+
+    case "POST":
+        payload, err := parseWebhookPayload(req)
+        if err == nil {
+            err = payload.validate(true)
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        hook := Webhook{Active:true}
+        payload.applyToWebhook(&hook)
+        if err = InsertNewWebhook(db, &hook); err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        c.Header().Set("Location", "/api/admin/webhooks/" + hook.Id.Hex() + "/")
+        writeJSON(c, http.StatusCreated, hook)
+
+    default:
+        methodNotAllowed(c, "GET", "HEAD", "POST")
+    }
+}
+
+// Single webhook: GET, PUT replaces, PATCH updates the sent fields, DELETE
+// removes it with its delivery log
+func WebhookResourceHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if !checkSuperuser(c, req) {
+        return
+    }
+    db := dbDefaultConn.DB(systemConf.DBName)
+    hookId := mux.Vars(req)["hookId"]
+
+    if !isReadRequest(req) && req.Method != "PUT" && req.Method != "PATCH" && req.Method != "DELETE" {
+        methodNotAllowed(c, "GET", "HEAD", "PUT", "PATCH", "DELETE")
+        return
+    }
+
+    hook, err := GetWebhook(db, hookId)
+    if err != nil {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+
+    switch req.Method {
+    case "GET", "HEAD":
+        writeJSON(c, http.StatusOK, hook)
+
+    case "PUT", "PATCH":
+        payload, err := parseWebhookPayload(req)
+        if err == nil {
+            err = payload.validate(req.Method == "PUT")
+        }
+        if err != nil {
+            writeJSONError(c, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        payload.applyToWebhook(&hook)
+        if err = UpdateWebhook(db, &hook); err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        writeJSON(c, http.StatusOK, hook)
+
+    case "DELETE":
+        if err = DeleteWebhook(db, hookId); err != nil {
+            writeJSONError(c, http.StatusInternalServerError, err.Error())
+            return
+        }
+        c.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// Delivery log of a webhook, newest first by default
+func WebhookDeliveriesHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if !checkSuperuser(c, req) {
+        return
+    }
+    if !isReadRequest(req) {
+        methodNotAllowed(c, "GET", "HEAD")
+        return
+    }
+    db := dbDefaultConn.DB(systemConf.DBName)
+    hookId := mux.Vars(req)["hookId"]
+
+    if _, err := GetWebhook(db, hookId); err != nil {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+
+    opts, err := parseListOptions(c, req, webhookDeliverySortFields, "-created", DEFAULT_PAGE_LIMIT)
+    if err != nil {
+        writeJSONError(c, http.StatusBadRequest, err.Error())
+        return
+    }
+    deliveries, total, err := FindWebhookDeliveries(db, hookId, opts)
+    if err != nil {
+        writeJSONError(c, http.StatusInternalServerError, err.Error())
+        return
+    }
+    setPaginationHeaders(c, req, opts, total)
+    writeJSON(c, http.StatusOK, map[string]interface{}{"deliveries":deliveries, "total":total, "page":opts.Page, "limit":opts.Limit})
+}
+
+// Queues a "ping" event to the webhook only, to test its endpoint
+func WebhookPingHandler(c http.ResponseWriter, req *http.Request) {
+    log.Println(req.Method, req.URL)
+    if !checkSuperuser(c, req) {
+        return
+    }
+    if req.Method != "POST" {
+        methodNotAllowed(c, "POST")
+        return
+    }
+    db := dbDefaultConn.DB(systemConf.DBName)
+
+    hook, err := GetWebhook(db, mux.Vars(req)["hookId"])
+    if err != nil {
+        writeJSONError(c, http.StatusNotFound, "Not found")
+        return
+    }
+
+    payload, err := json.Marshal(webhookMessage{Event:"ping", Timestamp:time.Now().UTC(), Data:map[string]string{"hook":hook.Id.Hex()}})
+    if err == nil {
+        var delivery WebhookDelivery
+        delivery, err = queueWebhookDelivery(db, hook, "ping", payload)
+        if err == nil {
+            writeJSON(c, http.StatusAccepted, delivery)
+            return
+        }
+    }
+    writeJSONError(c, http.StatusInternalServerError, err.Error())
+}
+
+// Registers the webhook administration routes
+func setWebhookUrls(r *mux.Router) {
+    r.HandleFunc("/api/admin/webhooks/", WebhookCollectionHandler).Methods("GET", "HEAD", "POST", "OPTIONS")
+    r.HandleFunc("/api/admin/webhooks/{hookId:" + OBJECT_ID_PATTERN + "}/", WebhookResourceHandler).Methods("GET", "HEAD", "PUT", "PATCH", "DELETE", "OPTIONS")
+    r.HandleFunc("/api/admin/webhooks/{hookId:" + OBJECT_ID_PATTERN + "}/deliveries/", WebhookDeliveriesHandler).Methods("GET", "HEAD", "OPTIONS")
+    r.HandleFunc("/api/admin/webhooks/{hookId:" + OBJECT_ID_PATTERN + "}/ping/", WebhookPingHandler).Methods("POST", "OPTIONS")
+}
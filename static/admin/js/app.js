@@ -23,6 +23,18 @@ var app = angular.module('mbAdmin', ['ui.bootstrap'], function($routeProvider, $
             templateUrl: '/templates/admin/photos.html',
             controller: PhotoCtrl
             })
+        .when('/categories/', {
+            templateUrl: '/templates/admin/categories.html',
+            controller: CategoryCtrl
+            })
+        .when('/tags/', {
+            templateUrl: '/templates/admin/tags.html',
+            controller: TagCtrl
+            })
+        .when('/api/', {
+            templateUrl: '/templates/admin/api.html',
+            controller: ApiCtrl
+            })
         .otherwise({redirectTo: '/404'});
 });
 
@@ -39,5 +51,35 @@ app.run(function($rootScope) {
         }
         return str;
     }
+
+    // Tags are edited as a comma separated string but sent as an array
+    $rootScope.splitTags = function(tags) {
+        if (!tags) {
+            return [];
+        } else if (angular.isArray(tags)) {
+            return tags;
+        }
+        var list = [];
+        angular.forEach(tags.split(","), function(tag){
+            tag = tag.replace(/^\s+|\s+$/g, "");
+            if (tag != "") {
+                list.push(tag);
+            }
+        });
+        return list;
+    }
+
+    // Flattens the category tree, with labels indented by depth
+    $rootScope.flattenCategories = function(nodes, depth, list) {
+        list = list || [];
+        depth = depth || 0;
+        angular.forEach(nodes, function(node){
+            node.Depth = depth;
+            node.Label = new Array(depth + 1).join("\u2014 ") + node.Name;
+            list.push(node);
+            $rootScope.flattenCategories(node.Children, depth + 1, list);
+        });
+        return list;
+    }
 });
 
@@ -24,7 +24,7 @@ function LoginCtrl($scope, $http) {
         };
 
         $scope.alerts = [];
-        $http.post('/login/', $scope.encodeUrlVars(params)).success(function(data){
+        $http.post('/login/', params).success(function(data){
             var type = data.result == 'error' ? 'error' : 'success';
             $scope.addAlert(data.message, type);
 
@@ -45,20 +45,113 @@ function LoginCtrl($scope, $http) {
     };
 }
 
+// Multi-select and bulk actions for a list of posts or pages. The list is
+// read from $scope[listName], and reload is called after each action.
+function setupBulkActions($scope, $http, url, listName, reload) {
+    $scope.selected = {};
+
+    $scope.selectedIds = function() {
+        var ids = [];
+        angular.forEach($scope[listName] || [], function(item){
+            if ($scope.selected[item.Id]) ids.push(item.Id);
+        });
+        return ids;
+    }
+    $scope.allSelected = function() {
+        var items = $scope[listName] || [];
+        return items.length > 0 && $scope.selectedIds().length == items.length;
+    }
+    $scope.toggleAll = function() {
+        var select = !$scope.allSelected();
+        angular.forEach($scope[listName] || [], function(item){
+            $scope.selected[item.Id] = select;
+        });
+    }
+
+    $scope.bulk = function(action) {
+        var params = {Ids: $scope.selectedIds(), Action: action};
+        if (params.Ids.length == 0) return;
+
+        if (action == 'delete' && !confirm("Delete "+params.Ids.length+" items?")) {
+            return;
+        } else if (action == 'retag') {
+            var tags = prompt("Tags, separated by commas. Prefix with + to add or - to remove a tag, or leave them plain to replace all tags.");
+            if (tags === null) return;
+            params.AddTags = [];
+            params.RemoveTags = [];
+            var replace = [];
+            angular.forEach($scope.splitTags(tags), function(tag){
+                if (tag.charAt(0) == '+') params.AddTags.push(tag.substr(1));
+                else if (tag.charAt(0) == '-') params.RemoveTags.push(tag.substr(1));
+                else replace.push(tag);
+            });
+            if (replace.length > 0 || (params.AddTags.length == 0 && params.RemoveTags.length == 0)) {
+                params.Tags = replace;
+            }
+        } else if (action == 'author') {
+            params.Author = prompt("New author");
+            if (!params.Author) return;
+        }
+
+        $http.post(url, params).success(function(data){
+            var errors = [];
+            angular.forEach(data.results, function(result){
+                if (result.result == 'error') errors.push(result.id+": "+result.message);
+            });
+            if (errors.length > 0) {
+                alert(data.failed+" of "+data.results.length+" items failed:\n"+errors.join("\n"));
+            }
+            $scope.selected = {};
+            reload();
+        }).error(function(data){
+            alert(data.message || "Bulk operation failed");
+        });
+    }
+}
+
+// Loads the published photos to choose the social image of posts and pages from
+function loadPhotoOptions($scope, $http) {
+    $http.get('/api/photo/').success(function(data){
+        $scope.photoOptions = data.photos;
+    });
+}
+
+// Renders content as the site does, with the errors of its shortcodes
+function setupPreview($scope, $http) {
+    $scope.preview = null;
+    $scope.showPreview = function(content) {
+        $http.post('/api/admin/preview/', {Content: content}).success(function(data){
+            $scope.preview = data;
+        });
+    }
+    $scope.closePreview = function() {
+        $scope.preview = null;
+    }
+}
+
 function BlogPostCtrl($scope, $http) {
     // Function to update blog post list
     $scope.updateBlogPosts = function() {
-        $http.get('/api/blog/post/').success(function(data){
+        $http.get('/api/blog/post/?published=all').success(function(data){
             $scope.blogPosts = data.posts;
         });
     }
     $scope.updateBlogPosts();
+    setupBulkActions($scope, $http, '/api/v2/posts/bulk/', 'blogPosts', $scope.updateBlogPosts);
+
+    // Categories to choose from in the form
+    $http.get('/api/category/').success(function(data){
+        $scope.categoryOptions = $scope.flattenCategories(data.categories);
+    });
+    loadPhotoOptions($scope, $http);
+    setupPreview($scope, $http);
        
     // Function to load blog post data
     $scope.getBlogPost = function(postId, callback) {
         $http.get('/api/blog/post/'+postId+'/')
-            .success(function(data){
+            .success(function(data, status, headers){
                 $scope.blogPost = data.post;
+                $scope.blogPostETag = headers('ETag');
                 if (callback) callback();
             });
     }
@@ -69,14 +162,23 @@ function BlogPostCtrl($scope, $http) {
             Title: $scope.blogPost.Title,
             Content: $scope.blogPost.Content,
             Slug: $scope.blogPost.Slug,
-            Tags: $scope.blogPost.Tags ? $scope.blogPost.Tags : ""
+            Tags: $scope.splitTags($scope.blogPost.Tags),
+            Categories: $scope.blogPost.Categories || [],
+            Seo: $scope.blogPost.Seo
         };
 
         var url = $scope.blogPost.Id ? '/api/blog/post/'+$scope.blogPost.Id+'/' : '/api/blog/post/add/';
+        var config = $scope.blogPost.Id ? {headers: {'If-Match': $scope.blogPostETag}} : {};
 
-        $http.post(url, $scope.encodeUrlVars(params)).success(function(data){
+        $http.post(url, params, config).success(function(data){
             $scope.updateBlogPosts();
             $scope.closeBlogPostForm();
+        }).error(function(data, status){
+            if (status == 412) {
+                alert("This post was changed by someone else. Reload it before saving.");
+            } else if (status == 400) {
+                alert(data);
+            }
         });
     }
 
@@ -101,7 +203,9 @@ function BlogPostCtrl($scope, $http) {
                 Title: "",
                 Content: "",
                 Slug: "",
-                Tags: ""
+                Tags: "",
+                Categories: [],
+                Seo: {}
             };
             $scope.openBlogPostForm = true;
         }
@@ -109,6 +213,7 @@ function BlogPostCtrl($scope, $http) {
     };
     $scope.closeBlogPostForm = function () {
         $scope.openBlogPostForm = false;
+        $scope.closePreview();
     };
 }
 
@@ -117,17 +222,21 @@ function PageCtrl($scope, $routeParams, $http, $location) {
 
     // Function to update blog post list
     $scope.updatePages = function() {
-        $http.get('/api/page/').success(function(data){
+        $http.get('/api/page/?published=all').success(function(data){
             $scope.pages = data.pages;
         });
     }
     $scope.updatePages();
+    setupBulkActions($scope, $http, '/api/v2/pages/bulk/', 'pages', $scope.updatePages);
+    loadPhotoOptions($scope, $http);
+    setupPreview($scope, $http);
     
     // Function to load page data
     $scope.getPage = function(pageId, callback) {
         $http.get('/api/page/'+pageId+'/')
-            .success(function(data){
+            .success(function(data, status, headers){
                 $scope.page = data.page;
+                $scope.pageETag = headers('ETag');
                 if (callback) callback();
             });
     }
@@ -138,14 +247,22 @@ function PageCtrl($scope, $routeParams, $http, $location) {
             Title: $scope.page.Title,
             Content: $scope.page.Content,
             Slug: $scope.page.Slug,
-            Tags: $scope.page.Tags ? $scope.page.Tags : ""
+            Tags: $scope.splitTags($scope.page.Tags),
+            Seo: $scope.page.Seo
         };
 
         var url = $scope.page.Id ? '/api/page/'+$scope.page.Id+'/' : '/api/page/add/';
+        var config = $scope.page.Id ? {headers: {'If-Match': $scope.pageETag}} : {};
 
-        $http.post(url, $scope.encodeUrlVars(params)).success(function(data){
+        $http.post(url, params, config).success(function(data){
             $scope.updatePages();
             $scope.closePageForm();
+        }).error(function(data, status){
+            if (status == 412) {
+                alert("This page was changed by someone else. Reload it before saving.");
+            } else if (status == 400) {
+                alert(data);
+            }
         });
     }
 
@@ -170,20 +287,22 @@ function PageCtrl($scope, $routeParams, $http, $location) {
                 Title: "",
                 Content: "",
                 Slug: "",
-                Tags: ""
+                Tags: "",
+                Seo: {}
             };
             $scope.openPageForm = true;
         }
     };
     $scope.closePageForm = function () {
         $scope.openPageForm = false;
+        $scope.closePreview();
     };
 }
 
 function PhotoCtrl($scope, $routeParams, $http, $location) {
     // Function to update blog post list
     $scope.updatePhotos = function() {
-        $http.get('/api/photo/').success(function(data){
+        $http.get('/api/photo/?published=all').success(function(data){
             $scope.photos = data.photos;
         });
     }
@@ -199,6 +318,149 @@ function PhotoCtrl($scope, $routeParams, $http, $location) {
     };
 }
 
+function CategoryCtrl($scope, $http) {
+    // Function to update the category tree
+    $scope.updateCategories = function() {
+        $http.get('/api/category/').success(function(data){
+            $scope.categories = $scope.flattenCategories(data.categories);
+        });
+    }
+    $scope.updateCategories();
+
+    $scope.submitCategoryForm = function() {
+        var params = {
+            Name: $scope.category.Name,
+            Slug: $scope.category.Slug,
+            Description: $scope.category.Description,
+            Parent: $scope.category.Parent || "",
+            Position: parseInt($scope.category.Position, 10) || 0
+        };
+        var request = $scope.category.Id ?
+            $http({method: 'PATCH', url: '/api/v2/categories/'+$scope.category.Id+'/', data: params}) :
+            $http.post('/api/v2/categories/', params);
+
+        request.success(function(data){
+            $scope.updateCategories();
+            $scope.closeCategoryForm();
+        }).error(function(data){
+            alert(data.message || "Category couldn't be saved");
+        });
+    }
+
+    // Subcategories move to the parent of the deleted category
+    $scope.deleteCategory = function(category) {
+        if (confirm("Delete the category \""+category.Name+"\"?")) {
+            $http({method: 'DELETE', url: '/api/v2/categories/'+category.Id+'/'}).success(function(){
+                $scope.updateCategories();
+            });
+        }
+    }
+
+    // Modal for form
+    $scope.showCategoryForm = function(category) {
+        $scope.category = category ? angular.copy(category) : {Name: "", Slug: "", Description: "", Parent: "", Position: 0};
+        $scope.openCategoryForm = true;
+    }
+    $scope.closeCategoryForm = function() {
+        $scope.openCategoryForm = false;
+    }
+}
+
+function TagCtrl($scope, $http) {
+    // Function to update the tags list, unpublished documents included
+    $scope.updateTags = function() {
+        $http.get('/api/tag/?published=all').success(function(data){
+            $scope.tags = data.tags;
+        });
+    }
+    $scope.updateTags();
+    $scope.selected = {};
+
+    $scope.selectedSlugs = function() {
+        var slugs = [];
+        angular.forEach($scope.tags || [], function(tag){
+            if ($scope.selected[tag.Slug]) slugs.push(tag.Slug);
+        });
+        return slugs;
+    }
+
+    var changed = function(data){
+        $scope.selected = {};
+        $scope.updateTags();
+    }
+    var failed = function(data){
+        alert(data.message || "Tags couldn't be changed");
+    }
+
+    // Renaming to the name of another tag merges both
+    $scope.renameTag = function(tag) {
+        var name = prompt("New name of the tag \""+tag.Name+"\"", tag.Name);
+        if (!name || name == tag.Name) return;
+        $http.post('/api/admin/tags/'+tag.Slug+'/rename/', {Name: name}).success(changed).error(failed);
+    }
+
+    $scope.mergeTags = function() {
+        var slugs = $scope.selectedSlugs();
+        if (slugs.length < 2) return;
+        var name = prompt("Name of the merged tag");
+        if (!name) return;
+        $http.post('/api/admin/tags/merge/', {Tags: slugs, Name: name}).success(changed).error(failed);
+    }
+}
+
+function ApiCtrl($scope, $http) {
+    // Loads the OpenAPI document and flattens it in a list of operations
+    $http.get('/api/openapi.json').success(function(data){
+        $scope.spec = data;
+        $scope.operations = [];
+        angular.forEach(data.paths, function(methods, path){
+            angular.forEach(methods, function(operation, method){
+                operation.method = method.toUpperCase();
+                operation.path = path;
+                operation.parameters = angular.copy(operation.parameters || []);
+                operation.multipart = !!(operation.requestBody && operation.requestBody.content['multipart/form-data']);
+                operation.body = operation.requestBody ? "{}" : "";
+                $scope.operations.push(operation);
+            });
+        });
+        $scope.operations.sort(function(a, b){
+            return a.path < b.path ? -1 : (a.path > b.path ? 1 : 0);
+        });
+    });
+
+    // Sends the request of an operation with the informed parameters
+    $scope.sendRequest = function(operation) {
+        var url = operation.path;
+        var query = [];
+        angular.forEach(operation.parameters, function(param){
+            if (param.in == 'path') {
+                url = url.replace('{'+param.name+'}', encodeURIComponent(param.value || ''));
+            } else if (param.value) {
+                query.push(param.name+'='+encodeURIComponent(param.value));
+            }
+        });
+        if (query.length) {
+            url += '?' + query.join('&');
+        }
+
+        var config = {method: operation.method, url: url};
+        if (operation.requestBody && operation.body) {
+            try {
+                config.data = angular.fromJson(operation.body);
+            } catch (e) {
+                operation.response = {status: 'Invalid JSON', data: e.message};
+                return;
+            }
+        }
+
+        $http(config).success(function(data, status){
+            operation.response = {status: status, data: data};
+        }).error(function(data, status){
+            operation.response = {status: status, data: data};
+        });
+    }
+}
+
 function closePhotosForm() {
     angular.element(document.getElementById('photos-page-header')).scope().closePhotosForm();
 }
@@ -8,6 +8,34 @@ var app = angular.module('mbApp', [], function($routeProvider, $locationProvider
             templateUrl: '/templates/home.html',
             controller: BlogPostCtrl
         })
+        .when('/blog/:postSlug', {
+            templateUrl: '/templates/post.html',
+            controller: PostCtrl
+        })
+        .when('/blog/:year/:month/:day/:postSlug', {
+            templateUrl: '/templates/post.html',
+            controller: PostCtrl
+        })
+        .when('/archive/:year', {
+            templateUrl: '/templates/archive.html',
+            controller: ArchiveCtrl
+        })
+        .when('/archive/:year/:month', {
+            templateUrl: '/templates/archive.html',
+            controller: ArchiveCtrl
+        })
+        .when('/category/:categorySlug', {
+            templateUrl: '/templates/category.html',
+            controller: CategoryCtrl
+        })
+        .when('/tag/:tagSlug', {
+            templateUrl: '/templates/tag.html',
+            controller: TagCtrl
+        })
+        .when('/search', {
+            templateUrl: '/templates/search.html',
+            controller: SearchCtrl
+        })
         .when('/404', {
             templateUrl: '/templates/404.html'
         })
@@ -32,6 +60,11 @@ app.run(function($rootScope) {
         return str;
     }
 
+    // Two digits month of an archive entry, as in its address
+    $rootScope.monthNumber = function(month) {
+        return (month.Month < 10 ? '0' : '') + month.Month;
+    }
+
     // Markdown processor
     var converter = new Showdown.converter();
     $rootScope.processMarkdown = function(raw) {
@@ -65,6 +98,135 @@ function BlogPostCtrl($scope, $http) {
         });
     }
     $scope.updateBlogPosts();
+
+    // Years and months to browse the posts by
+    $http.get('/api/blog/archive/').success(function(data){
+        $scope.archive = data.years;
+    });
+}
+
+function ArchiveCtrl($scope, $routeParams, $http) {
+    $scope.params = $routeParams;
+    var year = parseInt($scope.params.year, 10);
+    var month = parseInt($scope.params.month, 10) || 0;
+    var months = ['January', 'February', 'March', 'April', 'May', 'June', 'July',
+        'August', 'September', 'October', 'November', 'December'];
+
+    // Period in UTC, like the server groups posts
+    var since = month ? Date.UTC(year, month - 1, 1) : Date.UTC(year, 0, 1);
+    var until = month ? Date.UTC(year, month, 1) : Date.UTC(year + 1, 0, 1);
+    $scope.title = month ? months[month - 1] + ' ' + year : '' + year;
+
+    var query = $scope.encodeUrlVars({
+        since: new Date(since).toISOString(),
+        until: new Date(until - 1).toISOString(),
+        limit: 100
+    });
+    $http.get('/api/blog/post/?'+query).success(function(data){
+        $scope.blogPosts = data.posts;
+    });
+    $http.get('/api/blog/archive/').success(function(data){
+        $scope.archive = data.years;
+    });
+}
+
+function PostCtrl($scope, $routeParams, $http, $location) {
+    $scope.params = $routeParams;
+
+    $http.get('/api/blog/post/by-slug/'+$scope.params.postSlug+'/')
+        .success(function(data){
+            $scope.post = data.post;
+            $scope.previous = data.previous;
+            $scope.next = data.next;
+            $scope.categories = data.categories;
+            $scope.related = data.related;
+            // Rendered by the server, with the shortcodes
+            $scope.html = data.html;
+
+            // Old slugs and other permalink formats go to the current permalink.
+            // Routes are matched without the trailing slash.
+            if (data.post.Permalink.replace(/\/$/, '') != $location.path().replace(/\/$/, '')) {
+                $location.path(data.post.Permalink).replace();
+            }
+        })
+        .error(function(data, status, headers, config) {
+            if (status == 404) {
+                $location.path('/404?url='+$location.path());
+            }
+        });
+}
+
+function CategoryCtrl($scope, $routeParams, $http, $location) {
+    $scope.params = $routeParams;
+
+    $http.get('/api/category/'+$scope.params.categorySlug+'/')
+        .success(function(data){
+            $scope.category = data.category;
+            $scope.parents = data.path.slice(0, -1);
+
+            $http.get('/api/v2/categories/').success(function(data){
+                $scope.subcategories = [];
+                angular.forEach(data.categories, function(category){
+                    if (category.Parent == $scope.category.Id) {
+                        $scope.subcategories.push(category);
+                    }
+                });
+            });
+        })
+        .error(function(data, status, headers, config) {
+            if (status == 404) {
+                $location.path('/404?url=/category/'+$scope.params.categorySlug);
+            }
+        });
+
+    $http.get('/api/blog/post/?limit=100&category='+$scope.params.categorySlug).success(function(data){
+        $scope.blogPosts = data.posts;
+    });
+}
+
+function TagCtrl($scope, $routeParams, $http, $location) {
+    $scope.params = $routeParams;
+
+    $http.get('/api/tag/'+$scope.params.tagSlug+'/')
+        .success(function(data){
+            $scope.tag = data.tag;
+        })
+        .error(function(data, status, headers, config) {
+            if (status == 404) {
+                $location.path('/404?url=/tag/'+$scope.params.tagSlug);
+            }
+        });
+
+    $http.get('/api/blog/post/?limit=100&tag='+$scope.params.tagSlug).success(function(data){
+        $scope.blogPosts = data.posts;
+    });
+    $http.get('/api/page/?limit=100&tag='+$scope.params.tagSlug).success(function(data){
+        $scope.pages = data.pages;
+    });
+}
+
+function SearchCtrl($scope, $routeParams, $http, $location) {
+    $scope.query = $routeParams.q || '';
+    $scope.results = [];
+    $scope.total = 0;
+    var page = 0;
+
+    // Next page of results, added to the ones shown
+    $scope.loadMore = function() {
+        page++;
+        var query = $scope.encodeUrlVars({q: encodeURIComponent($scope.query), page: page});
+        $http.get('/api/search/?'+query).success(function(data){
+            $scope.results = $scope.results.concat(data.results);
+            $scope.total = data.total;
+        });
+    }
+    if ($scope.query) {
+        $scope.loadMore();
+    }
+
+    $scope.search = function() {
+        $location.path('/search/').search({q: $scope.query});
+    }
 }
 
 function PageCtrl($scope, $routeParams, $http, $location) {
@@ -80,6 +242,12 @@ function PageCtrl($scope, $routeParams, $http, $location) {
         $http.get('/api/page/by-slug/'+$scope.params.pageSlug+'/')
             .success(function(data){
                 $scope.pageInfo = data.page;
+                $scope.html = data.html;
+
+                // Requested by an old slug, redirected by the API
+                if (data.page.Slug != $scope.params.pageSlug) {
+                    $location.path('/'+data.page.Slug).replace();
+                }
             })
             .error(function(data, status, headers, config) {
                 if (status == 404) {